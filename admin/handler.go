@@ -0,0 +1,254 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+const (
+	methodPurge = "PURGE"
+	methodBan   = "BAN"
+
+	cachePath           = "/cache"
+	resourcesPath       = "/cache/resources"
+	representationsPath = "/cache/representations"
+
+	urlParam    = "url"
+	tagParam    = "tag"
+	hostParam   = "host"
+	prefixParam = "prefix"
+	regexpParam = "regexp"
+
+	authorizationField   = "Authorization"
+	wwwAuthenticateField = "WWW-Authenticate"
+	contentTypeField     = "Content-Type"
+	allowField           = "Allow"
+
+	bearer = "Bearer "
+)
+
+// Handler is an admin API handler to inspect and invalidate cached representations.
+//
+//	GET /cache                  shows how much of the cache is used.
+//	GET /cache/resources        lists cached resources and their representations.
+//	GET /cache/representations  lists cached representations.
+//	PURGE /cache?url=<URL>      removes representations of the URL.
+//	PURGE /cache?tag=<tag>      removes representations tagged with the cache tag.
+//	BAN /cache?prefix=<prefix>  removes representations whose path starts with the prefix.
+//	BAN /cache?regexp=<regexp>  removes representations whose path matches the regular expression.
+//	DELETE /cache               removes all the representations.
+//
+// Listings accept host, prefix, regexp, method and state=<fresh|stale|revalidate> for filtering
+// and offset and limit for pagination.
+// BAN also accepts host=<host> to limit the removal to the host.
+// Every request has to be authorized with `Authorization: Bearer <Token>`.
+type Handler struct {
+	Cache *cache.Handler
+	Token string
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// ServeHTTP inspects or removes cached representations and responds with the result as JSON.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set(wwwAuthenticateField, `Bearer realm="jesi"`)
+		writeProblem(w, http.StatusUnauthorized, "valid bearer token is required")
+		return
+	}
+
+	switch r.URL.Path {
+	case cachePath:
+		h.serveCache(w, r)
+	case resourcesPath, representationsPath:
+		if r.Method != http.MethodGet {
+			w.Header().Set(allowField, http.MethodGet)
+			writeProblem(w, http.StatusMethodNotAllowed, r.Method)
+			return
+		}
+		h.list(w, r)
+	default:
+		writeProblem(w, http.StatusNotFound, r.URL.Path)
+	}
+}
+
+func (h *Handler) serveCache(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"usage": h.Cache.Usage(),
+		})
+	case methodPurge:
+		switch {
+		case q.Get(urlParam) != "":
+			h.purgeURL(w, q.Get(urlParam))
+		case q.Get(tagParam) != "":
+			h.purgeTag(w, q.Get(tagParam))
+		default:
+			writeProblem(w, http.StatusBadRequest, "either url or tag is required")
+		}
+	case methodBan:
+		switch {
+		case q.Get(prefixParam) != "":
+			prefix := q.Get(prefixParam)
+			h.ban(w, r, q.Get(hostParam), func(path string) bool {
+				return strings.HasPrefix(path, prefix)
+			})
+		case q.Get(regexpParam) != "":
+			re, err := regexp.Compile(q.Get(regexpParam))
+			if err != nil {
+				writeProblem(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			h.ban(w, r, q.Get(hostParam), re.MatchString)
+		default:
+			writeProblem(w, http.StatusBadRequest, "either prefix or regexp is required")
+		}
+	case http.MethodDelete:
+		h.ban(w, r, "", func(string) bool {
+			return true
+		})
+	default:
+		w.Header().Set(allowField, strings.Join([]string{http.MethodGet, methodPurge, methodBan, http.MethodDelete}, ", "))
+		writeProblem(w, http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.Token == "" {
+		return false
+	}
+
+	a := r.Header.Get(authorizationField)
+	if !strings.HasPrefix(a, bearer) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(a, bearer)), []byte(h.Token)) == 1
+}
+
+func (h *Handler) purgeURL(w http.ResponseWriter, s string) {
+	u, err := url.Parse(s)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res := h.Cache.Purge(&http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+	})
+
+	var reps []*cache.Representation
+	if res != nil {
+		for _, rep := range res.Representations {
+			reps = append(reps, rep)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"url":     u,
+		"removed": len(reps),
+	}).Info("Purged a URL")
+
+	writeRemoved(w, reps)
+}
+
+func (h *Handler) purgeTag(w http.ResponseWriter, tag string) {
+	reps := h.Cache.PurgeTag(tag)
+
+	log.WithFields(log.Fields{
+		"tag":     tag,
+		"removed": len(reps),
+	}).Info("Purged a tag")
+
+	writeRemoved(w, reps)
+}
+
+func (h *Handler) ban(w http.ResponseWriter, r *http.Request, host string, match func(string) bool) {
+	ress := h.Cache.Ban(func(key cache.ResourceKey) bool {
+		if host != "" && host != key.Host {
+			return false
+		}
+		return match(key.Path)
+	})
+
+	var reps []*cache.Representation
+	for _, res := range ress {
+		for _, rep := range res.Representations {
+			reps = append(reps, rep)
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"query":   r.URL.RawQuery,
+		"method":  r.Method,
+		"removed": len(reps),
+	}).Info("Banned resources")
+
+	writeRemoved(w, reps)
+}
+
+type removed struct {
+	ID string `json:"id"`
+	cache.ResourceKey
+	Method string `json:"method"`
+}
+
+func writeRemoved(w http.ResponseWriter, reps []*cache.Representation) {
+	rs := make([]removed, len(reps))
+	for i, rep := range reps {
+		rs[i] = removed{
+			ID:          rep.ID.String(),
+			ResourceKey: rep.ResourceKey,
+			Method:      rep.Method,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"removed": rs,
+	})
+}
+
+// problem is an RFC7807 Problem Details.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set(contentTypeField, "application/problem+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Couldn't write a response")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set(contentTypeField, "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Couldn't write a response")
+	}
+}
@@ -0,0 +1,204 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	testCases := []struct {
+		method string
+		target string
+		token  string
+
+		status  int
+		removed []string
+		left    []string
+	}{
+		{ // without a token, it's unauthorized.
+			method: "PURGE",
+			target: "/cache?url=http://www.example.com/movies/1",
+
+			status: http.StatusUnauthorized,
+			left:   []string{"/movies/1", "/movies/2", "/roles/1", "/actors/1"},
+		},
+		{ // with a wrong token, it's unauthorized too.
+			method: "PURGE",
+			target: "/cache?url=http://www.example.com/movies/1",
+			token:  "wrong",
+
+			status: http.StatusUnauthorized,
+			left:   []string{"/movies/1", "/movies/2", "/roles/1", "/actors/1"},
+		},
+		{ // PURGE removes a URL.
+			method: "PURGE",
+			target: "/cache?url=http://www.example.com/movies/1",
+			token:  "secret",
+
+			status:  http.StatusOK,
+			removed: []string{"/movies/1"},
+			left:    []string{"/movies/2", "/roles/1", "/actors/1"},
+		},
+		{ // PURGE also removes a tag.
+			method: "PURGE",
+			target: "/cache?tag=movie-1",
+			token:  "secret",
+
+			status:  http.StatusOK,
+			removed: []string{"/movies/1", "/roles/1"},
+			left:    []string{"/movies/2", "/actors/1"},
+		},
+		{ // PURGE requires either url or tag.
+			method: "PURGE",
+			target: "/cache",
+			token:  "secret",
+
+			status: http.StatusBadRequest,
+			left:   []string{"/movies/1", "/movies/2", "/roles/1", "/actors/1"},
+		},
+		{ // BAN removes by a path prefix.
+			method: "BAN",
+			target: "/cache?prefix=/movies/",
+			token:  "secret",
+
+			status:  http.StatusOK,
+			removed: []string{"/movies/1", "/movies/2"},
+			left:    []string{"/roles/1", "/actors/1"},
+		},
+		{ // BAN removes by a regular expression.
+			method: "BAN",
+			target: "/cache?regexp=" + url.QueryEscape(`\A/(?:roles|actors)/\d+\z`),
+			token:  "secret",
+
+			status:  http.StatusOK,
+			removed: []string{"/roles/1", "/actors/1"},
+			left:    []string{"/movies/1", "/movies/2"},
+		},
+		{ // BAN can be limited to a host.
+			method: "BAN",
+			target: "/cache?prefix=/&host=www.example.org",
+			token:  "secret",
+
+			status: http.StatusOK,
+			left:   []string{"/movies/1", "/movies/2", "/roles/1", "/actors/1"},
+		},
+		{ // BAN fails with an invalid regular expression.
+			method: "BAN",
+			target: "/cache?regexp=(",
+			token:  "secret",
+
+			status: http.StatusBadRequest,
+			left:   []string{"/movies/1", "/movies/2", "/roles/1", "/actors/1"},
+		},
+		{ // DELETE removes everything.
+			method: http.MethodDelete,
+			target: "/cache",
+			token:  "secret",
+
+			status:  http.StatusOK,
+			removed: []string{"/movies/1", "/movies/2", "/roles/1", "/actors/1"},
+		},
+		{ // other methods are not allowed.
+			method: http.MethodPost,
+			target: "/cache",
+			token:  "secret",
+
+			status: http.StatusMethodNotAllowed,
+			left:   []string{"/movies/1", "/movies/2", "/roles/1", "/actors/1"},
+		},
+		{ // other paths are not found.
+			method: "PURGE",
+			target: "/foo?url=http://www.example.com/movies/1",
+			token:  "secret",
+
+			status: http.StatusNotFound,
+			left:   []string{"/movies/1", "/movies/2", "/roles/1", "/actors/1"},
+		},
+	}
+
+	for i, tc := range testCases {
+		store := &cache.Store{}
+		for path, tags := range map[string]string{
+			"/movies/1": "movie-1",
+			"/movies/2": "movie-2",
+			"/roles/1":  "movie-1 actor-1",
+			"/actors/1": "actor-1",
+		} {
+			store.Set(testRequest(path), &cache.Representation{
+				HeaderMap: http.Header{"Surrogate-Key": []string{tags}},
+				Body:      []byte(`{}`),
+			})
+		}
+
+		u, err := url.Parse(tc.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &http.Request{
+			Method: tc.method,
+			URL:    u,
+			Header: http.Header{},
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		h := Handler{Cache: &cache.Handler{Storage: store}, Token: "secret"}
+		var rep cache.Representation
+		h.ServeHTTP(&rep, req)
+
+		if tc.status != rep.StatusCode {
+			t.Errorf("(%d) expected: %d, got: %d", i, tc.status, rep.StatusCode)
+		}
+
+		if tc.status == http.StatusOK {
+			var body struct {
+				Removed []struct {
+					ID   string `json:"id"`
+					Host string `json:"host"`
+					Path string `json:"path"`
+				} `json:"removed"`
+			}
+			if err := json.Unmarshal(rep.Body, &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tc.removed) != len(body.Removed) {
+				t.Errorf("(%d) expected: %d, got: %d", i, len(tc.removed), len(body.Removed))
+			}
+
+			for _, path := range tc.removed {
+				found := false
+				for _, r := range body.Removed {
+					if path == r.Path && r.Host == "www.example.com" && r.ID != "" {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("(%d) expected %s to be removed, got: %s", i, path, string(rep.Body))
+				}
+			}
+		}
+
+		for _, path := range tc.left {
+			if store.Get(testRequest(path)) == nil {
+				t.Errorf("(%d) expected %s to be left, got nil", i, path)
+			}
+		}
+	}
+}
+
+func testRequest(path string) *http.Request {
+	return &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Scheme: "http",
+			Host:   "www.example.com",
+			Path:   path,
+		},
+	}
+}
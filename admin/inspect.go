@@ -0,0 +1,248 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+const (
+	methodParam = "method"
+	stateParam  = "state"
+	offsetParam = "offset"
+	limitParam  = "limit"
+
+	defaultLimit = 100
+)
+
+type representation struct {
+	ID string `json:"id"`
+	cache.ResourceKey
+	Method       string      `json:"method"`
+	Key          string      `json:"key,omitempty"`
+	StatusCode   int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Size         uint64      `json:"size"`
+	Age          int64       `json:"age"`
+	State        string      `json:"state"`
+	LastUsedTime time.Time   `json:"last_used_time"`
+}
+
+type resource struct {
+	cache.ResourceKey
+	Unique          bool             `json:"unique,omitempty"`
+	Fields          []string         `json:"fields,omitempty"`
+	Representations []representation `json:"representations"`
+}
+
+type page struct {
+	Total  int           `json:"total"`
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+	Items  interface{}   `json:"items"`
+	Usage  []cache.Usage `json:"usage"`
+}
+
+type entry struct {
+	res  *cache.Resource
+	rep  representation
+	orig *cache.Representation
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	f, err := newFilter(q)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	offset, limit, err := pagination(q)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var es []entry
+	h.Cache.Each(func(res *cache.Resource, rep *cache.Representation, size uint64) {
+		es = append(es, entry{
+			res: res,
+			rep: representation{
+				ID:           rep.ID.String(),
+				ResourceKey:  rep.ResourceKey,
+				Method:       rep.Method,
+				Key:          rep.Key,
+				StatusCode:   rep.StatusCode,
+				Header:       rep.HeaderMap,
+				Size:         size,
+				LastUsedTime: rep.LastUsedTime,
+			},
+			orig: rep,
+		})
+	})
+
+	var reps []entry
+	for _, e := range es {
+		state, _ := h.Cache.State(&http.Request{
+			Method: e.rep.Method,
+			URL: &url.URL{
+				Host:     e.rep.Host,
+				Path:     e.rep.Path,
+				RawQuery: e.rep.Query,
+			},
+			Header: http.Header{},
+		}, e.orig)
+		e.rep.State = state.String()
+		e.rep.Age = int64(cache.Age(e.orig) / time.Second)
+
+		if f.match(&e.rep) {
+			reps = append(reps, e)
+		}
+	}
+
+	sort.Slice(reps, func(i, j int) bool {
+		a, b := reps[i].rep, reps[j].rep
+		if a.ResourceKey != b.ResourceKey {
+			return keyLess(a.ResourceKey, b.ResourceKey)
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Key < b.Key
+	})
+
+	var items []interface{}
+	if r.URL.Path == resourcesPath {
+		var last *resource
+		for _, e := range reps {
+			if last == nil || last.ResourceKey != e.rep.ResourceKey {
+				items = append(items, &resource{
+					ResourceKey: e.rep.ResourceKey,
+					Unique:      e.res.Unique,
+					Fields:      e.res.Fields,
+				})
+				last = items[len(items)-1].(*resource)
+			}
+			last.Representations = append(last.Representations, e.rep)
+		}
+	} else {
+		for _, e := range reps {
+			items = append(items, e.rep)
+		}
+	}
+
+	p := page{
+		Total:  len(items),
+		Offset: offset,
+		Limit:  limit,
+		Items:  []interface{}{},
+		Usage:  h.Cache.Usage(),
+	}
+	if offset < len(items) {
+		// offset + limit might overflow.
+		end := len(items)
+		if limit < end-offset {
+			end = offset + limit
+		}
+		p.Items = items[offset:end]
+	}
+
+	writeJSON(w, http.StatusOK, &p)
+}
+
+func keyLess(a, b cache.ResourceKey) bool {
+	if a.Host != b.Host {
+		return a.Host < b.Host
+	}
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	return a.Query < b.Query
+}
+
+type filter struct {
+	host   string
+	prefix string
+	re     *regexp.Regexp
+	method string
+	state  string
+}
+
+func newFilter(q url.Values) (*filter, error) {
+	f := filter{
+		host:   q.Get(hostParam),
+		prefix: q.Get(prefixParam),
+		method: q.Get(methodParam),
+		state:  strings.ToLower(q.Get(stateParam)),
+	}
+
+	if s := q.Get(regexpParam); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		f.re = re
+	}
+
+	return &f, nil
+}
+
+func (f *filter) match(rep *representation) bool {
+	if f.host != "" && f.host != rep.Host {
+		return false
+	}
+
+	if !strings.HasPrefix(rep.Path, f.prefix) {
+		return false
+	}
+
+	if f.re != nil && !f.re.MatchString(rep.Path) {
+		return false
+	}
+
+	if f.method != "" && f.method != rep.Method {
+		return false
+	}
+
+	if f.state != "" && f.state != rep.State {
+		return false
+	}
+
+	return true
+}
+
+func pagination(q url.Values) (int, int, error) {
+	offset, err := intParam(q, offsetParam, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	limit, err := intParam(q, limitParam, defaultLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return offset, limit, nil
+}
+
+func intParam(q url.Values, key string, def int) (int, error) {
+	s := q.Get(key)
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", key, s)
+	}
+
+	return n, nil
+}
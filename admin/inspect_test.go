@@ -0,0 +1,170 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_list(t *testing.T) {
+	testCases := []struct {
+		target string
+
+		status int
+		total  int
+		paths  []string
+		states []string
+	}{
+		{ // it lists representations sorted by URL.
+			target: "/cache/representations",
+
+			status: http.StatusOK,
+			total:  4,
+			paths:  []string{"/actors/1", "/movies/1", "/movies/2", "/roles/1"},
+			states: []string{"fresh", "fresh", "stale", "revalidate"},
+		},
+		{ // it filters representations by a path prefix.
+			target: "/cache/representations?prefix=/movies/",
+
+			status: http.StatusOK,
+			total:  2,
+			paths:  []string{"/movies/1", "/movies/2"},
+			states: []string{"fresh", "stale"},
+		},
+		{ // it filters representations by a regular expression.
+			target: "/cache/representations?regexp=" + url.QueryEscape(`/1\z`),
+
+			status: http.StatusOK,
+			total:  3,
+			paths:  []string{"/actors/1", "/movies/1", "/roles/1"},
+			states: []string{"fresh", "fresh", "revalidate"},
+		},
+		{ // it filters representations by a state.
+			target: "/cache/representations?state=fresh",
+
+			status: http.StatusOK,
+			total:  2,
+			paths:  []string{"/actors/1", "/movies/1"},
+			states: []string{"fresh", "fresh"},
+		},
+		{ // it paginates representations.
+			target: "/cache/representations?offset=1&limit=2",
+
+			status: http.StatusOK,
+			total:  4,
+			paths:  []string{"/movies/1", "/movies/2"},
+			states: []string{"fresh", "stale"},
+		},
+		{ // it paginates representations with a huge limit.
+			target: "/cache/representations?offset=2&limit=9223372036854775807",
+
+			status: http.StatusOK,
+			total:  4,
+			paths:  []string{"/movies/2", "/roles/1"},
+			states: []string{"stale", "revalidate"},
+		},
+		{ // it lists resources too.
+			target: "/cache/resources?host=www.example.com&offset=3",
+
+			status: http.StatusOK,
+			total:  4,
+			paths:  []string{"/roles/1"},
+			states: []string{"revalidate"},
+		},
+		{ // it fails with an invalid limit.
+			target: "/cache/resources?limit=-1",
+
+			status: http.StatusBadRequest,
+		},
+	}
+
+	now := time.Now()
+	store := &cache.Store{Max: 1024}
+	for path, cc := range map[string]string{
+		"/movies/1": "max-age=60",
+		"/movies/2": "max-age=1",
+		"/roles/1":  "no-store",
+		"/actors/1": "max-age=60",
+	} {
+		store.Set(testRequest(path), &cache.Representation{
+			StatusCode:   http.StatusOK,
+			HeaderMap:    http.Header{"Cache-Control": []string{cc}},
+			Body:         []byte(`{}`),
+			RequestTime:  now.Add(-3 * time.Second),
+			ResponseTime: now.Add(-2 * time.Second),
+		})
+	}
+
+	h := Handler{Cache: &cache.Handler{Storage: store}, Token: "secret"}
+
+	for i, tc := range testCases {
+		u, err := url.Parse(tc.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var rep cache.Representation
+		h.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL:    u,
+			Header: http.Header{
+				"Authorization": []string{"Bearer secret"},
+			},
+		})
+
+		if tc.status != rep.StatusCode {
+			t.Errorf("(%d) expected: %d, got: %d", i, tc.status, rep.StatusCode)
+		}
+
+		if tc.status != http.StatusOK {
+			continue
+		}
+
+		var body struct {
+			Total int `json:"total"`
+			Items []struct {
+				Path            string `json:"path"`
+				State           string `json:"state"`
+				Age             int64  `json:"age"`
+				Representations []struct {
+					State string `json:"state"`
+				} `json:"representations"`
+			} `json:"items"`
+			Usage []cache.Usage `json:"usage"`
+		}
+		if err := json.Unmarshal(rep.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if tc.total != body.Total {
+			t.Errorf("(%d) [total] expected: %d, got: %d", i, tc.total, body.Total)
+		}
+
+		if len(tc.paths) != len(body.Items) {
+			t.Errorf("(%d) [len(items)] expected: %d, got: %d", i, len(tc.paths), len(body.Items))
+			continue
+		}
+
+		for j, item := range body.Items {
+			if tc.paths[j] != item.Path {
+				t.Errorf("(%d, %d) [path] expected: %s, got: %s", i, j, tc.paths[j], item.Path)
+			}
+
+			state := item.State
+			if len(item.Representations) > 0 {
+				state = item.Representations[0].State
+			}
+			if tc.states[j] != state {
+				t.Errorf("(%d, %d) [state] expected: %s, got: %s", i, j, tc.states[j], state)
+			}
+		}
+
+		if len(body.Usage) != 1 || body.Usage[0].InUse != 8 || body.Usage[0].Max != 1024 {
+			t.Errorf("(%d) [usage] expected: 8/1024, got: %#v", i, body.Usage)
+		}
+	}
+}
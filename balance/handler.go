@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -39,20 +40,27 @@ var _ http.Handler = (*Handler)(nil)
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r = cloneReq(r)
-	if err := h.direct(r); err != nil {
+	b, err := h.direct(r)
+	if err != nil {
 		w.WriteHeader(http.StatusBadGateway)
 		return
 	}
 	removeHopByHops(r.Header)
 	h.addForwarded(r)
 	addXForwarded(r)
-	h.Next.ServeHTTP(w, r)
+
+	sw := &statusWriter{ResponseWriter: w}
+	start := time.Now()
+	h.Next.ServeHTTP(sw, r)
+	requestDuration.Since(start, b.String())
+	requests.Inc(b.String(), sw.code())
+
 	removeHopByHops(w.Header())
 }
 
 var errBackendNotFound = errors.New("backend not found")
 
-func (h *Handler) direct(r *http.Request) error {
+func (h *Handler) direct(r *http.Request) (*Backend, error) {
 	b := h.BackendPool.Next()
 
 	if b == nil {
@@ -60,7 +68,7 @@ func (h *Handler) direct(r *http.Request) error {
 			"id": transaction.ID(r),
 		}).Error("Couldn't find a backend in the pool")
 
-		return errBackendNotFound
+		return nil, errBackendNotFound
 	}
 
 	log.WithFields(log.Fields{
@@ -76,7 +84,7 @@ func (h *Handler) direct(r *http.Request) error {
 		"url": r.URL,
 	}).Debug("Directed a request to a backend")
 
-	return nil
+	return b, nil
 }
 
 func cloneReq(old *http.Request) *http.Request {
@@ -0,0 +1,57 @@
+package balance
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+
+	"github.com/ichiban/jesi/metrics"
+)
+
+var (
+	requests        = metrics.NewCounter("jesi_backend_requests_total", "Number of requests to backends by status code.", "backend", "code")
+	requestDuration = metrics.NewHistogram("jesi_backend_request_duration_seconds", "Latencies of requests to backends.", "backend")
+)
+
+// RegisterMetrics registers metrics of the health of the backends.
+func RegisterMetrics(p *BackendPool) {
+	metrics.NewGaugeFunc("jesi_backend_healthy", "Whether the backend is healthy (1) or sick (0).", func() []metrics.Sample {
+		p.RLock()
+		defer p.RUnlock()
+
+		var ss []metrics.Sample
+		for _, q := range []struct {
+			list  *list.List
+			value float64
+		}{
+			{list: &p.Healthy, value: 1},
+			{list: &p.Sick, value: 0},
+		} {
+			for e := q.list.Front(); e != nil; e = e.Next() {
+				ss = append(ss, metrics.Sample{
+					Labels: []string{e.Value.(*Backend).String()},
+					Value:  q.value,
+				})
+			}
+		}
+		return ss
+	}, "backend")
+}
+
+// statusWriter remembers the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) code() string {
+	if w.status == 0 {
+		return strconv.Itoa(http.StatusOK)
+	}
+	return strconv.Itoa(w.status)
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ichiban/jesi/transaction"
+)
+
+// flight is an upstream fetch shared by concurrent requests for the same representation.
+type flight struct {
+	done chan struct{}
+	req  *http.Request
+	rep  *Representation // nil if the result can't be shared.
+}
+
+type flightKey struct {
+	ResourceKey
+	RepresentationKey
+}
+
+// flightGroup keeps track of upstream fetches in flight.
+type flightGroup struct {
+	sync.Mutex
+	flights map[flightKey]*flight
+}
+
+// join returns a flight for the key and true if the caller is the leader which is responsible for the fetch.
+func (g *flightGroup) join(key flightKey, req *http.Request) (*flight, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.flights == nil {
+		g.flights = make(map[flightKey]*flight)
+	}
+
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+
+	f := &flight{
+		done: make(chan struct{}),
+		req:  req,
+	}
+	g.flights[key] = f
+	return f, true
+}
+
+// land notifies the waiters of the result.
+func (g *flightGroup) land(key flightKey, f *flight) {
+	g.Lock()
+	delete(g.flights, key)
+	g.Unlock()
+
+	close(f.done)
+}
+
+// share returns a clone of the result if it's also a representation for the request.
+func (f *flight) share(req *http.Request) *Representation {
+	if f.rep == nil {
+		return nil
+	}
+
+	f.rep.RLock()
+	defer f.rep.RUnlock()
+
+	// the result might vary on header fields which differ between the requests.
+	res := NewResource(f.req, f.rep)
+	if res.Unique {
+		return nil
+	}
+	if NewRepresentationKey(res, f.req) != NewRepresentationKey(res, req) {
+		return nil
+	}
+
+	return f.rep.clone()
+}
+
+// collapse coalesces concurrent fetches for the same representation so that only one of them hits the upstream.
+// The others wait for the result and fetch on their own only if the result isn't shareable.
+func (h *Handler) collapse(r *http.Request, state CachedState, cached *Representation, d *diagnosticWriter) *Representation {
+	key, ok := newFlightKey(r, cached)
+	if !ok {
+		rep, _ := h.fetch(r, state, cached, d)
+		return rep
+	}
+
+	f, leader := h.flights.join(key, r)
+	if leader {
+		defer h.flights.land(key, f)
+
+		rep, shareable := h.fetch(r, state, cached, d)
+		if shareable {
+			f.rep = rep
+		}
+		return rep
+	}
+
+	log.WithFields(log.Fields{
+		"id":     transaction.ID(r),
+		"leader": transaction.ID(f.req),
+	}).Debug("Will wait for a fetch in flight")
+
+	start := time.Now()
+	<-f.done
+
+	if rep := f.share(r); rep != nil {
+		collapsed.Inc()
+		d.timings = append(d.timings, Timing{Name: "upstream", Desc: "collapsed", Duration: time.Since(start)})
+		return rep
+	}
+
+	log.WithFields(log.Fields{
+		"id":     transaction.ID(r),
+		"leader": transaction.ID(f.req),
+	}).Debug("Couldn't share a fetch in flight")
+
+	rep, _ := h.fetch(r, state, cached, d)
+	return rep
+}
+
+// newFlightKey returns a key to coalesce the request and true if it can be coalesced.
+// Requests with credentials are never coalesced since their responses might be private.
+func newFlightKey(r *http.Request, cached *Representation) (flightKey, bool) {
+	if !idempotent(r) {
+		return flightKey{}, false
+	}
+
+	if _, ok := r.Header[authorizationField]; ok {
+		return flightKey{}, false
+	}
+
+	// without the cached representation, variants are unknown until the response arrives.
+	if cached == nil {
+		return flightKey{
+			ResourceKey: NewResourceKey(r),
+			RepresentationKey: RepresentationKey{
+				Method: r.Method,
+			},
+		}, true
+	}
+
+	cached.RLock()
+	defer cached.RUnlock()
+
+	res := NewResource(r, cached)
+	if res.Unique {
+		return flightKey{}, false
+	}
+
+	return flightKey{
+		ResourceKey:       res.ResourceKey,
+		RepresentationKey: NewRepresentationKey(res, r),
+	}, true
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandler_ServeHTTP_collapse(t *testing.T) {
+	testCases := []struct {
+		header  http.Header
+		cached  http.Header // header fields of stale representations cached for each request if any.
+		headers []http.Header
+
+		calls   int
+		latency int // upper bound of the response time in multiples of the upstream delay.
+	}{
+		{ // cacheable responses are shared
+			header: http.Header{"Cache-Control": []string{"max-age=60"}},
+			headers: []http.Header{
+				{}, {}, {}, {}, {},
+			},
+			calls:   1,
+			latency: 2,
+		},
+		{ // uncacheable responses aren't shared
+			header: http.Header{"Cache-Control": []string{"private"}},
+			headers: []http.Header{
+				{}, {}, {},
+			},
+			calls:   3,
+			latency: 3,
+		},
+		{ // responses aren't shared if they vary on different header fields
+			header: http.Header{"Cache-Control": []string{"max-age=60"}, "Vary": []string{"Accept-Language"}},
+			headers: []http.Header{
+				{"Accept-Language": []string{"en"}},
+				{"Accept-Language": []string{"ja"}},
+			},
+			calls:   2,
+			latency: 3,
+		},
+		{ // requests for different cached variants aren't collapsed
+			header: http.Header{"Cache-Control": []string{"max-age=60"}, "Vary": []string{"Accept-Language"}},
+			cached: http.Header{"Cache-Control": []string{"max-age=1, must-revalidate"}, "Vary": []string{"Accept-Language"}},
+			headers: []http.Header{
+				{"Accept-Language": []string{"en"}},
+				{"Accept-Language": []string{"ja"}},
+				{"Accept-Language": []string{"fr"}},
+			},
+			calls:   3,
+			latency: 2,
+		},
+		{ // requests for the same cached variant are collapsed
+			header: http.Header{"Cache-Control": []string{"max-age=60"}, "Vary": []string{"Accept-Language"}},
+			cached: http.Header{"Cache-Control": []string{"max-age=1, must-revalidate"}, "Vary": []string{"Accept-Language"}},
+			headers: []http.Header{
+				{"Accept-Language": []string{"en"}},
+				{"Accept-Language": []string{"en"}},
+				{"Accept-Language": []string{"en"}},
+			},
+			calls:   1,
+			latency: 2,
+		},
+		{ // requests with credentials aren't collapsed
+			header: http.Header{"Cache-Control": []string{"public, max-age=60"}},
+			headers: []http.Header{
+				{"Authorization": []string{"Bearer a"}},
+				{"Authorization": []string{"Bearer b"}},
+			},
+			calls:   2,
+			latency: 2,
+		},
+	}
+
+	const delay = 100 * time.Millisecond
+
+	for i, tc := range testCases {
+		backend := &slowHandler{
+			delay: delay,
+			rep: &Representation{
+				StatusCode: http.StatusOK,
+				HeaderMap:  tc.header,
+				Body:       []byte(`{"foo":"bar"}`),
+			},
+		}
+		h := Handler{
+			Next:    backend,
+			Storage: &Store{},
+		}
+
+		if tc.cached != nil {
+			for _, header := range tc.headers {
+				req := testRequest("/test")
+				req.Header = header
+				h.Set(req, &Representation{
+					StatusCode:   http.StatusOK,
+					HeaderMap:    tc.cached,
+					Body:         []byte(`{"foo":"old"}`),
+					RequestTime:  time.Now().Add(-3 * time.Second),
+					ResponseTime: time.Now().Add(-2 * time.Second),
+				})
+			}
+		}
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		reps := make([]Representation, len(tc.headers))
+		for j, header := range tc.headers {
+			req := testRequest("/test")
+			req.Header = header
+
+			wg.Add(1)
+			go func(rep *Representation) {
+				defer wg.Done()
+				h.ServeHTTP(rep, req)
+			}(&reps[j])
+		}
+		wg.Wait()
+		latency := time.Since(start)
+
+		if tc.calls != backend.calls {
+			t.Errorf("(%d) expected %d, got %d", i, tc.calls, backend.calls)
+		}
+
+		if max := time.Duration(tc.latency) * delay; latency >= max {
+			t.Errorf("(%d) expected less than %s, got %s", i, max, latency)
+		}
+
+		for j := range reps {
+			if rep := &reps[j]; string(rep.Body) != `{"foo":"bar"}` {
+				t.Errorf(`(%d) expected {"foo":"bar"}, got %s`, i, string(rep.Body))
+			}
+		}
+	}
+}
+
+type slowHandler struct {
+	sync.Mutex
+	delay time.Duration
+	rep   *Representation
+	calls int
+}
+
+func (h *slowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	h.calls++
+	h.Unlock()
+
+	time.Sleep(h.delay)
+
+	h.rep.WriteTo(w)
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	diagnosticsField  = "Jesi-Diagnostics"
+	xCacheField       = "X-Cache"
+	serverTimingField = "Server-Timing"
+)
+
+// Diagnostics decides whether diagnostic header fields X-Cache and Server-Timing are added to responses.
+// Since they reveal the internals of the cache, they're added only when Always is set
+// or the request has `Jesi-Diagnostics: <Token>` header field.
+type Diagnostics struct {
+	Always bool
+	Token  string
+}
+
+type contextKey int
+
+// enabledKey is a key for whether diagnostics are enabled for requests stripped of the token.
+const enabledKey contextKey = iota
+
+// Enabled returns true if diagnostic header fields should be added to the response to the request.
+func (d *Diagnostics) Enabled(r *http.Request) bool {
+	if d == nil {
+		return false
+	}
+
+	if d.Always {
+		return true
+	}
+
+	if enabled, ok := r.Context().Value(enabledKey).(bool); ok {
+		return enabled
+	}
+
+	if d.Token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(diagnosticsField)), []byte(d.Token)) == 1
+}
+
+// Strip returns a copy of the request without `Jesi-Diagnostics` header field so that the token isn't sent to upstream.
+// Whether diagnostics are enabled for the request is kept in the context of the copy.
+func (d *Diagnostics) Strip(r *http.Request) *http.Request {
+	if _, ok := r.Header[diagnosticsField]; !ok {
+		return r
+	}
+
+	c := r.WithContext(context.WithValue(r.Context(), enabledKey, d.Enabled(r)))
+	c.Header = make(http.Header, len(r.Header))
+	for k, vs := range r.Header {
+		c.Header[k] = vs
+	}
+	delete(c.Header, diagnosticsField)
+	return c
+}
+
+// Timing is a metric in Server-Timing header field.
+type Timing struct {
+	Name     string
+	Desc     string
+	Duration time.Duration
+}
+
+func (t Timing) String() string {
+	dur := float64(t.Duration) / float64(time.Millisecond)
+	if t.Desc == "" {
+		return fmt.Sprintf("%s;dur=%.3f", t.Name, dur)
+	}
+	return fmt.Sprintf("%s;desc=%q;dur=%.3f", t.Name, t.Desc, dur)
+}
+
+// AddTimings appends metrics to Server-Timing header field.
+func AddTimings(h http.Header, ts ...Timing) {
+	// the values might be shared with cached representations.
+	vs := make([]string, len(h[serverTimingField]), len(h[serverTimingField])+len(ts))
+	copy(vs, h[serverTimingField])
+	for _, t := range ts {
+		vs = append(vs, t.String())
+	}
+	h[serverTimingField] = vs
+}
+
+// DelDiagnostics removes diagnostic header fields X-Cache and Server-Timing.
+func DelDiagnostics(h http.Header) {
+	delete(h, xCacheField)
+	delete(h, serverTimingField)
+}
+
+const (
+	xCacheHit         = "HIT"
+	xCacheMiss        = "MISS"
+	xCacheStale       = "STALE"
+	xCacheRevalidated = "REVALIDATED"
+)
+
+// diagnosticWriter adds diagnostic header fields right before the header is written.
+type diagnosticWriter struct {
+	http.ResponseWriter
+
+	status  string
+	timings []Timing
+}
+
+func (w *diagnosticWriter) WriteHeader(code int) {
+	h := w.Header()
+	h.Set(xCacheField, w.status)
+	AddTimings(h, w.timings...)
+	w.ResponseWriter.WriteHeader(code)
+}
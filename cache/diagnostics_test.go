@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiagnostics_Enabled(t *testing.T) {
+	testCases := []struct {
+		diagnostics *Diagnostics
+		header      http.Header
+
+		enabled bool
+	}{
+		{ // disabled by default
+			diagnostics: nil,
+			header:      http.Header{"Jesi-Diagnostics": []string{"secret"}},
+			enabled:     false,
+		},
+		{ // always enabled
+			diagnostics: &Diagnostics{Always: true},
+			header:      http.Header{},
+			enabled:     true,
+		},
+		{ // enabled with the token
+			diagnostics: &Diagnostics{Token: "secret"},
+			header:      http.Header{"Jesi-Diagnostics": []string{"secret"}},
+			enabled:     true,
+		},
+		{ // disabled with a wrong token
+			diagnostics: &Diagnostics{Token: "secret"},
+			header:      http.Header{"Jesi-Diagnostics": []string{"wrong"}},
+			enabled:     false,
+		},
+		{ // disabled without the token
+			diagnostics: &Diagnostics{},
+			header:      http.Header{"Jesi-Diagnostics": []string{""}},
+			enabled:     false,
+		},
+	}
+
+	for i, tc := range testCases {
+		enabled := tc.diagnostics.Enabled(&http.Request{Header: tc.header})
+		if tc.enabled != enabled {
+			t.Errorf("(%d) expected %t, got %t", i, tc.enabled, enabled)
+		}
+	}
+}
+
+func TestDiagnostics_Strip(t *testing.T) {
+	d := &Diagnostics{Token: "secret"}
+
+	for _, tc := range []struct {
+		token   string
+		enabled bool
+	}{
+		{token: "secret", enabled: true},
+		{token: "wrong", enabled: false},
+	} {
+		req := &http.Request{Header: http.Header{"Jesi-Diagnostics": []string{tc.token}, "Accept": []string{"application/json"}}}
+		stripped := d.Strip(req)
+
+		if _, ok := stripped.Header["Jesi-Diagnostics"]; ok {
+			t.Errorf("(%s) expected no Jesi-Diagnostics, got %v", tc.token, stripped.Header)
+		}
+		if a := stripped.Header.Get("Accept"); a != "application/json" {
+			t.Errorf("(%s) expected application/json, got %s", tc.token, a)
+		}
+		if enabled := d.Enabled(stripped); tc.enabled != enabled {
+			t.Errorf("(%s) expected %t, got %t", tc.token, tc.enabled, enabled)
+		}
+
+		// the original request keeps the token.
+		if v := req.Header.Get("Jesi-Diagnostics"); v != tc.token {
+			t.Errorf("(%s) expected %s, got %s", tc.token, tc.token, v)
+		}
+	}
+
+	// requests without the token are returned as they are.
+	req := &http.Request{Header: http.Header{}}
+	if stripped := d.Strip(req); stripped != req {
+		t.Errorf("expected %p, got %p", req, stripped)
+	}
+}
+
+func TestTiming_String(t *testing.T) {
+	testCases := []struct {
+		timing Timing
+		str    string
+	}{
+		{
+			timing: Timing{Name: "upstream", Duration: 1500 * time.Microsecond},
+			str:    "upstream;dur=1.500",
+		},
+		{
+			timing: Timing{Name: "embed", Desc: "roles[0].actor", Duration: 12 * time.Millisecond},
+			str:    `embed;desc="roles[0].actor";dur=12.000`,
+		},
+	}
+
+	for i, tc := range testCases {
+		if tc.str != tc.timing.String() {
+			t.Errorf("(%d) expected %s, got %s", i, tc.str, tc.timing.String())
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_diagnostics(t *testing.T) {
+	backend := &testHandler{
+		Resources: map[string]*Representation{
+			"http://www.example.com/test": {
+				StatusCode: http.StatusOK,
+				HeaderMap: http.Header{
+					"Cache-Control": []string{"public, max-age=600"},
+					"Server-Timing": []string{"db;dur=10"},
+				},
+				Body: []byte(`{"foo":"bar"}`),
+			},
+		},
+	}
+	h := Handler{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the token isn't sent to upstream.
+			if v, ok := r.Header["Jesi-Diagnostics"]; ok {
+				t.Errorf("expected no Jesi-Diagnostics, got %v", v)
+			}
+			backend.ServeHTTP(w, r)
+		}),
+		Storage:     &Store{},
+		Diagnostics: &Diagnostics{Token: "secret"},
+	}
+
+	testCases := []struct {
+		header http.Header
+
+		xCache  string
+		timings []string
+	}{
+		{
+			header:  http.Header{"Jesi-Diagnostics": []string{"secret"}},
+			xCache:  "MISS",
+			timings: []string{"db;dur=10", `cache;desc="lookup";dur=`, "upstream;dur="},
+		},
+		{
+			header:  http.Header{"Jesi-Diagnostics": []string{"secret"}},
+			xCache:  "HIT",
+			timings: []string{"db;dur=10", `cache;desc="lookup";dur=`},
+		},
+		{
+			header:  http.Header{},
+			xCache:  "",
+			timings: []string{"db;dur=10"},
+		},
+	}
+
+	for i, tc := range testCases {
+		req := testRequest("/test")
+		req.Header = tc.header
+
+		var rep Representation
+		h.ServeHTTP(&rep, req)
+
+		if xCache := rep.HeaderMap.Get("X-Cache"); tc.xCache != xCache {
+			t.Errorf("(%d) expected %s, got %s", i, tc.xCache, xCache)
+		}
+
+		timings := rep.HeaderMap["Server-Timing"]
+		if len(tc.timings) != len(timings) {
+			t.Fatalf("(%d) expected %d, got %#v", i, len(tc.timings), timings)
+		}
+		for j, timing := range tc.timings {
+			if !strings.HasPrefix(timings[j], timing) {
+				t.Errorf("(%d) expected %s, got %s", i, timing, timings[j])
+			}
+		}
+	}
+
+	// cached representations don't have diagnostic header fields.
+	cached := h.Get(testRequest("/test"))
+	if vs := cached.HeaderMap["Server-Timing"]; len(vs) != 1 {
+		t.Errorf("expected 1, got %#v", vs)
+	}
+	if _, ok := cached.HeaderMap["X-Cache"]; ok {
+		t.Errorf("expected no X-Cache, got %#v", cached.HeaderMap)
+	}
+}
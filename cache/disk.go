@@ -0,0 +1,632 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ichiban/jesi/transaction"
+)
+
+const (
+	metaExt = ".json"
+	tmpExt  = ".tmp"
+)
+
+// DiskStore stores pairs of request/response on disk.
+// It keeps an index of representations in memory while their bodies and metadata are stored as files in Dir
+// so that they can be loaded again after restarts.
+type DiskStore struct {
+	sync.RWMutex
+	Dir             string
+	Resources       map[ResourceKey]*Resource
+	Representations map[uuid.UUID]*Representation
+	Max             uint64
+	InUse           uint64
+	Sample          uint
+	Hits            uint64
+	Misses          uint64
+	Evictions       uint64
+
+	sizes map[uuid.UUID]uint64
+	tags  tagIndex
+}
+
+// Set inserts/updates a new pair of request/response to the cache.
+func (s *DiskStore) Set(req *http.Request, rep *Representation) {
+	s.init()
+
+	s.RLock()
+	res, ok := s.Resources[NewResourceKey(req)]
+	s.RUnlock()
+	if !ok {
+		res = NewResource(req, rep)
+	}
+
+	rep.ResourceKey = res.ResourceKey
+	rep.RepresentationKey = NewRepresentationKey(res, req)
+
+	if !s.put(res, rep) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"id":          rep.ID,
+		"transaction": transaction.ID(req),
+	}).Info("Added a representation")
+}
+
+// put stores a representation whose keys are already set.
+func (s *DiskStore) put(res *Resource, rep *Representation) bool {
+	// revalidated representations are clones without IDs.
+	if uuid.Equal(rep.ID, uuid.Nil) {
+		rep.ID, _ = uuid.NewV4()
+	}
+
+	// the metadata is written after the body so that a body with its metadata is always complete.
+	if err := writeFile(s.path(rep.ID), rep.Body); err != nil {
+		log.WithFields(log.Fields{
+			"id":    rep.ID,
+			"error": err,
+		}).Error("Couldn't write a representation")
+		return false
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	r, ok := s.Resources[rep.ResourceKey]
+	if !ok {
+		r = &Resource{
+			ResourceKey:     rep.ResourceKey,
+			Unique:          res.Unique,
+			Fields:          res.Fields,
+			Representations: make(map[RepresentationKey]*Representation),
+		}
+	}
+
+	rep.LastUsedTime = time.Now()
+
+	if err := writeMeta(s.path(rep.ID)+metaExt, r, rep); err != nil {
+		log.WithFields(log.Fields{
+			"id":    rep.ID,
+			"error": err,
+		}).Error("Couldn't write a representation")
+		s.removeFiles(rep.ID)
+		return false
+	}
+
+	s.Resources[r.ResourceKey] = r
+
+	if old, ok := r.Representations[rep.RepresentationKey]; ok && !uuid.Equal(old.ID, rep.ID) {
+		s.remove(old)
+	}
+	s.InUse -= s.sizes[rep.ID]
+
+	meta := rep.clone()
+	meta.Body = nil
+	meta.ID = rep.ID
+	meta.ResourceKey = rep.ResourceKey
+	meta.RepresentationKey = rep.RepresentationKey
+	meta.LastUsedTime = rep.LastUsedTime
+	s.add(r, meta, uint64(len(rep.Body)))
+
+	s.evictIfNeeded()
+
+	return true
+}
+
+// demote stores a representation evicted from another store.
+func (s *DiskStore) demote(res *Resource, rep *Representation) {
+	s.init()
+
+	if !s.put(res, rep) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"id": rep.ID,
+	}).Info("Demoted a representation")
+}
+
+func (s *DiskStore) add(res *Resource, meta *Representation, size uint64) {
+	res.Representations[meta.RepresentationKey] = meta
+	s.Representations[meta.ID] = meta
+	s.sizes[meta.ID] = size
+	s.tags.add(meta)
+	s.InUse += size
+}
+
+func (s *DiskStore) evictIfNeeded() {
+	if s.Max == 0 {
+		return
+	}
+
+	for s.InUse > s.Max {
+		s.evict()
+	}
+}
+
+func (s *DiskStore) evict() {
+	var minRep *Representation
+	i := s.Sample
+	for _, rep := range s.Representations {
+		if i == 0 {
+			break
+		}
+
+		if minRep != nil && less(minRep, rep) {
+			continue
+		}
+
+		minRep = rep
+
+		i--
+	}
+
+	res := s.Resources[minRep.ResourceKey]
+	delete(res.Representations, minRep.RepresentationKey)
+	if len(res.Representations) == 0 {
+		delete(s.Resources, res.ResourceKey)
+	}
+
+	s.remove(minRep)
+	s.Evictions++
+}
+
+// remove deletes the representation from the index and its body from the disk.
+func (s *DiskStore) remove(rep *Representation) {
+	delete(s.Representations, rep.ID)
+	s.tags.remove(rep)
+	s.InUse -= s.sizes[rep.ID]
+	delete(s.sizes, rep.ID)
+
+	s.removeFiles(rep.ID)
+
+	log.WithFields(log.Fields{
+		"id": rep.ID,
+	}).Info("Removed a representation")
+}
+
+func (s *DiskStore) removeFiles(id uuid.UUID) {
+	removeFile(s.path(id))
+	removeFile(s.path(id) + metaExt)
+}
+
+func removeFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{
+			"path":  path,
+			"error": err,
+		}).Error("Couldn't remove a file")
+	}
+}
+
+// Get retrieves a cached response.
+func (s *DiskStore) Get(req *http.Request) *Representation {
+	s.init()
+
+	s.RLock()
+	defer s.RUnlock()
+
+	rep := s.lookup(req)
+	if rep == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadFile(s.path(rep.ID))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    rep.ID,
+			"error": err,
+		}).Error("Couldn't read a representation")
+		return nil
+	}
+
+	rep.Lock()
+	defer rep.Unlock()
+	rep.LastUsedTime = time.Now()
+
+	log.WithFields(log.Fields{
+		"id": rep.ID,
+	}).Info("Get a representation")
+
+	c := rep.clone()
+	c.Body = body
+	return c
+}
+
+// take retrieves a cached response and removes it from the store.
+func (s *DiskStore) take(req *http.Request) *Representation {
+	s.init()
+
+	s.Lock()
+	defer s.Unlock()
+
+	rep := s.lookup(req)
+	if rep == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadFile(s.path(rep.ID))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    rep.ID,
+			"error": err,
+		}).Error("Couldn't read a representation")
+		return nil
+	}
+
+	res := s.Resources[rep.ResourceKey]
+	delete(res.Representations, rep.RepresentationKey)
+	if len(res.Representations) == 0 {
+		delete(s.Resources, res.ResourceKey)
+	}
+	s.remove(rep)
+
+	c := rep.clone()
+	c.ID = rep.ID
+	c.Body = body
+	return c
+}
+
+func (s *DiskStore) lookup(req *http.Request) *Representation {
+	res, ok := s.Resources[NewResourceKey(req)]
+	if !ok {
+		atomic.AddUint64(&s.Misses, 1)
+		return nil
+	}
+
+	rep, ok := res.Representations[NewRepresentationKey(res, req)]
+	if !ok {
+		atomic.AddUint64(&s.Misses, 1)
+		return nil
+	}
+
+	atomic.AddUint64(&s.Hits, 1)
+
+	return rep
+}
+
+// Purge removes any representations associated to the request.
+func (s *DiskStore) Purge(req *http.Request) *Resource {
+	s.init()
+
+	s.Lock()
+	defer s.Unlock()
+
+	resKey := NewResourceKey(req)
+	res, ok := s.Resources[resKey]
+	if !ok {
+		return nil
+	}
+	delete(s.Resources, resKey)
+
+	for _, rep := range res.Representations {
+		s.remove(rep)
+	}
+
+	return res
+}
+
+// Ban removes any resources matching the given condition.
+func (s *DiskStore) Ban(match func(ResourceKey) bool) []*Resource {
+	s.init()
+
+	s.Lock()
+	defer s.Unlock()
+
+	var ress []*Resource
+	for resKey, res := range s.Resources {
+		if !match(resKey) {
+			continue
+		}
+		delete(s.Resources, resKey)
+
+		for _, rep := range res.Representations {
+			s.remove(rep)
+		}
+
+		ress = append(ress, res)
+	}
+
+	return ress
+}
+
+// PurgeTag removes any representations tagged with the given cache tag.
+func (s *DiskStore) PurgeTag(tag string) []*Representation {
+	s.init()
+
+	s.Lock()
+	defer s.Unlock()
+
+	var reps []*Representation
+	for id := range s.tags[tag] {
+		rep, ok := s.Representations[id]
+		if !ok {
+			continue
+		}
+
+		if res, ok := s.Resources[rep.ResourceKey]; ok {
+			delete(res.Representations, rep.RepresentationKey)
+			if len(res.Representations) == 0 {
+				delete(s.Resources, res.ResourceKey)
+			}
+		}
+
+		s.remove(rep)
+		reps = append(reps, rep)
+	}
+
+	return reps
+}
+
+// Each calls f for each cached representation with its size. The representation doesn't have its body.
+func (s *DiskStore) Each(f func(res *Resource, rep *Representation, size uint64)) {
+	s.init()
+
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, res := range s.Resources {
+		for _, rep := range res.Representations {
+			f(res, rep.snapshot(), s.sizes[rep.ID])
+		}
+	}
+}
+
+// Usage returns how much of the store is used.
+func (s *DiskStore) Usage() []Usage {
+	s.init()
+
+	s.RLock()
+	defer s.RUnlock()
+
+	return []Usage{
+		{
+			Tier:            "disk",
+			Resources:       len(s.Resources),
+			Representations: len(s.Representations),
+			InUse:           s.InUse,
+			Max:             s.Max,
+			Hits:            atomic.LoadUint64(&s.Hits),
+			Misses:          atomic.LoadUint64(&s.Misses),
+			Evictions:       s.Evictions,
+		},
+	}
+}
+
+func (s *DiskStore) init() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Resources == nil {
+		s.Resources = make(map[ResourceKey]*Resource)
+	}
+	if s.Representations == nil {
+		s.Representations = make(map[uuid.UUID]*Representation)
+	}
+	if s.sizes == nil {
+		s.sizes = make(map[uuid.UUID]uint64)
+	}
+	if s.tags == nil {
+		s.tags = make(tagIndex)
+	}
+}
+
+func (s *DiskStore) path(id uuid.UUID) string {
+	return filepath.Join(s.Dir, id.String())
+}
+
+// Load reads representations stored in Dir by previous runs and adds them to the index.
+// It creates Dir if it doesn't exist.
+func (s *DiskStore) Load() error {
+	s.init()
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+
+	fs, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	metas := make(map[string]struct{}, len(fs))
+	for _, f := range fs {
+		metas[f.Name()] = struct{}{}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, f := range fs {
+		name := f.Name()
+
+		// temporary files and bodies without metadata are left by writes interrupted by crashes.
+		if strings.HasSuffix(name, tmpExt) {
+			removeFile(filepath.Join(s.Dir, name))
+			continue
+		}
+		if _, err := uuid.FromString(name); err == nil {
+			if _, ok := metas[name+metaExt]; !ok {
+				removeFile(filepath.Join(s.Dir, name))
+			}
+			continue
+		}
+
+		if !strings.HasSuffix(name, metaExt) {
+			continue
+		}
+
+		id, err := uuid.FromString(strings.TrimSuffix(name, metaExt))
+		if err != nil {
+			continue
+		}
+
+		body, err := os.Stat(s.path(id))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":    id,
+				"error": err,
+			}).Warn("Couldn't find a body of a representation")
+			s.removeFiles(id)
+			continue
+		}
+
+		res, meta, size, err := readMeta(filepath.Join(s.Dir, name))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":    id,
+				"error": err,
+			}).Warn("Couldn't read a representation")
+			s.removeFiles(id)
+			continue
+		}
+
+		if uint64(body.Size()) != size {
+			log.WithFields(log.Fields{
+				"id":       id,
+				"size":     body.Size(),
+				"expected": size,
+			}).Warn("Found a truncated body of a representation")
+			s.removeFiles(id)
+			continue
+		}
+
+		if r, ok := s.Resources[res.ResourceKey]; ok {
+			res = r
+		} else {
+			s.Resources[res.ResourceKey] = res
+		}
+
+		if old, ok := res.Representations[meta.RepresentationKey]; ok {
+			if less(meta, old) {
+				s.removeFiles(meta.ID)
+				continue
+			}
+			s.remove(old)
+		}
+
+		s.add(res, meta, uint64(body.Size()))
+
+		log.WithFields(log.Fields{
+			"id": meta.ID,
+		}).Info("Loaded a representation")
+	}
+
+	s.evictIfNeeded()
+
+	return nil
+}
+
+// diskMeta is a representation stored in a file except its body.
+type diskMeta struct {
+	ID                uuid.UUID         `json:"id"`
+	ResourceKey       ResourceKey       `json:"resource"`
+	Unique            bool              `json:"unique,omitempty"`
+	Fields            []string          `json:"fields,omitempty"`
+	RepresentationKey RepresentationKey `json:"representation"`
+	Size              uint64            `json:"size"`
+	StatusCode        int               `json:"status"`
+	HeaderMap         http.Header       `json:"header"`
+	RequestTime       time.Time         `json:"request_time"`
+	ResponseTime      time.Time         `json:"response_time"`
+	LastUsedTime      time.Time         `json:"last_used_time"`
+}
+
+func writeMeta(path string, res *Resource, rep *Representation) error {
+	b, err := json.Marshal(diskMeta{
+		ID:                rep.ID,
+		ResourceKey:       rep.ResourceKey,
+		Unique:            res.Unique,
+		Fields:            res.Fields,
+		RepresentationKey: rep.RepresentationKey,
+		Size:              uint64(len(rep.Body)),
+		StatusCode:        rep.StatusCode,
+		HeaderMap:         rep.HeaderMap,
+		RequestTime:       rep.RequestTime,
+		ResponseTime:      rep.ResponseTime,
+		LastUsedTime:      rep.LastUsedTime,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFile(path, b)
+}
+
+// readMeta returns the resource and the representation without its body along with the size of the body.
+func readMeta(path string) (*Resource, *Representation, uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	var m diskMeta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, nil, 0, err
+	}
+
+	res := Resource{
+		ResourceKey:     m.ResourceKey,
+		Unique:          m.Unique,
+		Fields:          m.Fields,
+		Representations: make(map[RepresentationKey]*Representation),
+	}
+	rep := Representation{
+		ResourceKey:       m.ResourceKey,
+		RepresentationKey: m.RepresentationKey,
+		ID:                m.ID,
+		StatusCode:        m.StatusCode,
+		HeaderMap:         m.HeaderMap,
+		RequestTime:       m.RequestTime,
+		ResponseTime:      m.ResponseTime,
+		LastUsedTime:      m.LastUsedTime,
+	}
+	if rep.HeaderMap == nil {
+		rep.HeaderMap = http.Header{}
+	}
+	return &res, &rep, m.Size, nil
+}
+
+// writeFile writes the data to a temporary file and renames it to the path after syncing
+// so that the file at the path is never partially written even if the process crashes.
+func writeFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*"+tmpExt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
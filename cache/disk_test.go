@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func TestDiskStore_Set(t *testing.T) {
+	testCases := []struct {
+		max  uint64
+		sets []string
+
+		inUse uint64
+		files int
+		found map[string]string
+	}{
+		{ // when there's no entry for the request (insert)
+			sets: []string{`/test {"foo":"bar"}`},
+
+			inUse: 13,
+			files: 2,
+			found: map[string]string{
+				"/test": `{"foo":"bar"}`,
+			},
+		},
+		{ // when there's an existing entry for the request (replace)
+			sets: []string{`/test {"test":"ok"}`, `/test {}`},
+
+			inUse: 2,
+			files: 2,
+			found: map[string]string{
+				"/test": `{}`,
+			},
+		},
+		{ // when it exceeds the limit
+			max:  13,
+			sets: []string{`/foo {"foo":"ok"}`, `/test {"test":"ok"}`},
+
+			inUse: 13,
+			files: 2,
+			found: map[string]string{
+				"/foo":  "",
+				"/test": `{"test":"ok"}`,
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		dir, err := ioutil.TempDir("", "jesi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		store := DiskStore{Dir: dir, Max: tc.max, Sample: 2}
+		for _, s := range tc.sets {
+			ts := strings.SplitN(s, " ", 2)
+			store.Set(testRequest(ts[0]), &Representation{Body: []byte(ts[1])})
+		}
+
+		if tc.inUse != store.InUse {
+			t.Errorf("(%d) [InUse] expected: %d, got: %d", i, tc.inUse, store.InUse)
+		}
+
+		fs, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.files != len(fs) {
+			t.Errorf("(%d) [files] expected: %d, got: %d", i, tc.files, len(fs))
+		}
+
+		for path, body := range tc.found {
+			rep := store.Get(testRequest(path))
+			if body == "" {
+				if rep != nil {
+					t.Errorf("(%d) [%s] expected nil, got %#v", i, path, rep)
+				}
+				continue
+			}
+			if rep == nil {
+				t.Errorf("(%d) [%s] expected non-nil, got nil", i, path)
+				continue
+			}
+			if body != string(rep.Body) {
+				t.Errorf("(%d) [%s] expected: %s, got: %s", i, path, body, string(rep.Body))
+			}
+		}
+	}
+}
+
+func TestDiskStore_Purge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jesi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := DiskStore{Dir: dir}
+	store.Set(testRequest("/test"), &Representation{Body: []byte(`{"test":"ok"}`)})
+	store.Set(testRequest("/foo"), &Representation{Body: []byte(`{"foo":"bar"}`)})
+
+	if res := store.Purge(testRequest("/bar")); res != nil {
+		t.Errorf("expected nil, got %#v", res)
+	}
+
+	if res := store.Purge(testRequest("/test")); res == nil {
+		t.Error("expected non-nil, got nil")
+	}
+
+	if rep := store.Get(testRequest("/test")); rep != nil {
+		t.Errorf("expected nil, got %#v", rep)
+	}
+
+	if rep := store.Get(testRequest("/foo")); rep == nil {
+		t.Error("expected non-nil, got nil")
+	}
+
+	if store.InUse != 13 {
+		t.Errorf("expected 13, got %d", store.InUse)
+	}
+
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 2 {
+		t.Errorf("expected 2, got %d", len(fs))
+	}
+}
+
+func TestDiskStore_PurgeTag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jesi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := DiskStore{Dir: dir}
+	store.Set(testRequest("/test"), &Representation{
+		HeaderMap: http.Header{"Surrogate-Key": []string{"test"}},
+		Body:      []byte(`{"test":"ok"}`),
+	})
+	store.Set(testRequest("/foo"), &Representation{
+		HeaderMap: http.Header{"Surrogate-Key": []string{"foo"}},
+		Body:      []byte(`{"foo":"bar"}`),
+	})
+
+	if reps := store.PurgeTag("test"); len(reps) != 1 {
+		t.Errorf("expected 1, got %d", len(reps))
+	}
+
+	if rep := store.Get(testRequest("/test")); rep != nil {
+		t.Errorf("expected nil, got %#v", rep)
+	}
+
+	if rep := store.Get(testRequest("/foo")); rep == nil {
+		t.Error("expected non-nil, got nil")
+	}
+
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 2 {
+		t.Errorf("expected 2, got %d", len(fs))
+	}
+}
+
+func testRequest(path string) *http.Request {
+	return &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Scheme: "http",
+			Host:   "www.example.com",
+			Path:   path,
+		},
+	}
+}
+
+func TestDiskStore_Load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jesi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+
+	old := DiskStore{Dir: dir}
+	old.Set(testRequest("/foo"), &Representation{
+		StatusCode: http.StatusOK,
+		Body:       []byte(`{"foo":"bar"}`),
+	})
+	old.Set(&http.Request{
+		Method: http.MethodGet,
+		URL:    testRequest("/test").URL,
+		Header: http.Header{
+			"Accept": []string{"application/json"},
+		},
+	}, &Representation{
+		StatusCode: http.StatusOK,
+		HeaderMap: http.Header{
+			"Cache-Control": []string{"max-age=60"},
+			"Vary":          []string{"Accept"},
+		},
+		Body:         []byte(`{"test":"ok"}`),
+		RequestTime:  now.Add(-2 * time.Second),
+		ResponseTime: now.Add(-1 * time.Second),
+	})
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "garbage.json"), []byte(`{`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// files left by writes interrupted by crashes.
+	truncated := Representation{
+		StatusCode: http.StatusOK,
+		Body:       []byte(`{"bar":"baz"}`),
+	}
+	old.Set(testRequest("/bar"), &truncated)
+	if err := os.Truncate(old.path(truncated.ID), 5); err != nil {
+		t.Fatal(err)
+	}
+	orphan, _ := uuid.NewV4()
+	if err := ioutil.WriteFile(old.path(orphan), []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tmp := old.path(orphan) + metaExt + ".1" + tmpExt
+	if err := ioutil.WriteFile(tmp, []byte(`{`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := DiskStore{Dir: dir, Max: 13, Sample: 2}
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.InUse != 13 {
+		t.Errorf("expected 13, got %d", store.InUse)
+	}
+
+	for _, p := range []string{old.path(truncated.ID), old.path(truncated.ID) + metaExt, old.path(orphan), tmp} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", p, err)
+		}
+	}
+
+	if rep := store.Get(testRequest("/foo")); rep != nil {
+		t.Errorf("expected to be evicted, got %#v", rep)
+	}
+
+	if rep := store.Get(testRequest("/test")); rep != nil {
+		t.Errorf("expected to be varied, got %#v", rep)
+	}
+
+	rep := store.Get(&http.Request{
+		Method: http.MethodGet,
+		URL:    testRequest("/test").URL,
+		Header: http.Header{
+			"Accept": []string{"application/json"},
+		},
+	})
+	if rep == nil {
+		t.Fatal("expected non-nil, got nil")
+	}
+
+	if rep.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", rep.StatusCode)
+	}
+
+	if string(rep.Body) != `{"test":"ok"}` {
+		t.Errorf("expected %s, got %s", `{"test":"ok"}`, string(rep.Body))
+	}
+
+	if rep.HeaderMap.Get("Cache-Control") != "max-age=60" {
+		t.Errorf("expected max-age=60, got %s", rep.HeaderMap.Get("Cache-Control"))
+	}
+
+	if !rep.ResponseTime.Equal(now.Add(-1 * time.Second)) {
+		t.Errorf("expected %s, got %s", now.Add(-1*time.Second), rep.ResponseTime)
+	}
+
+	var h Handler
+	if s, _ := h.State(testRequest("/test"), rep); s != Fresh {
+		t.Errorf("expected fresh, got %s", s)
+	}
+}
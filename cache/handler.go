@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -26,32 +27,77 @@ var (
 	revalidatePattern = regexp.MustCompile(`\A(?:s-maxage=\d+|(?:must|proxy)-revalidate)\z`)
 
 	maxStalePattern = regexp.MustCompile(`\Amax-stale=(\d+)\z`)
+
+	staleWhileRevalidatePattern = regexp.MustCompile(`\Astale-while-revalidate=(\d+)\z`)
+	mustRevalidatePattern       = regexp.MustCompile(`\A(?:must|proxy)-revalidate\z`)
+	staleIfErrorPattern         = regexp.MustCompile(`\Astale-if-error=(\d+)\z`)
 )
 
 // Handler is a caching handler.
 type Handler struct {
 	Next http.Handler
-	*Store
+	Storage
+	Diagnostics *Diagnostics
+
+	OriginChangedAt time.Time
+
+	flights flightGroup
+
+	// keys of cached representations being revalidated in background.
+	revalidating struct {
+		sync.Mutex
+		keys map[revalidationKey]struct{}
+	}
+}
+
+// revalidationKey identifies a cached representation being revalidated in background.
+type revalidationKey struct {
+	ResourceKey
+	RepresentationKey
 }
 
 var _ http.Handler = (*Handler)(nil)
 
 // ServeHTTP returns a cached response if found. Otherwise, retrieves one from the underlying handler.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = h.Diagnostics.Strip(r)
+
+	start := time.Now()
 	cached := h.Get(r)
 	state, delta := h.State(r, cached)
 
+	d := &diagnosticWriter{
+		ResponseWriter: w,
+		status:         xCacheMiss,
+		timings: []Timing{
+			{Name: "cache", Desc: "lookup", Duration: time.Since(start)},
+		},
+	}
+	if h.Diagnostics.Enabled(r) {
+		w = d
+	}
+
 	log.WithFields(log.Fields{
 		"id":    transaction.ID(r),
 		"state": state,
 		"delta": delta,
 	}).Debug("Got a state of a representation")
 
+	requests.Inc(state.String())
+
 	switch state {
 	case Fresh:
+		d.status = xCacheHit
 		serveFresh(w, cached, r)
 		return
 	case Stale:
+		if swr, ok := staleWhileRevalidate(cached); ok && delta <= swr {
+			d.status = xCacheStale
+			serveStale(w, cached, r)
+			h.revalidateInBackground(r, cached)
+			return
+		}
+
 		if max := maxStale(cached); max < delta {
 			log.WithFields(log.Fields{
 				"id":        transaction.ID(r),
@@ -62,26 +108,32 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
+		d.status = xCacheStale
 		serveStale(w, cached, r)
 		return
 	case Revalidate:
 		r = revalidateRequest(r, cached)
 	}
 
+	rep := h.collapse(r, state, cached, d)
+	if _, err := rep.WriteTo(w); err != nil {
+		log.WithFields(log.Fields{
+			"id":    transaction.ID(r),
+			"error": err,
+		}).Error("Couldn't write a response")
+	}
+}
+
+// fetch retrieves a representation from the underlying handler and caches it if possible.
+// It also reports whether the resulting representation can be shared with other requests.
+func (h *Handler) fetch(r *http.Request, state CachedState, cached *Representation, d *diagnosticWriter) (*Representation, bool) {
 	// Keep the original request since `balance.Handler` will modify the request.
 	origReq := *r
 	origURL := *r.URL
 	origReq.URL = &origURL
 
 	rep := NewRepresentation(h.Next, r)
-	defer func() {
-		if _, err := rep.WriteTo(w); err != nil {
-			log.WithFields(log.Fields{
-				"id":    transaction.ID(r),
-				"error": err,
-			}).Error("Couldn't write a response")
-		}
-	}()
+	d.timings = append(d.timings, Timing{Name: "upstream", Duration: rep.ResponseTime.Sub(rep.RequestTime)})
 
 	if !rep.Successful() {
 		log.WithFields(log.Fields{
@@ -89,14 +141,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"status": rep.StatusCode,
 		}).Debug("Couldn't get a successful response")
 
-		if state == Stale {
+		if staleIfError(r, state, cached, rep) {
 			log.WithFields(log.Fields{
 				"id": transaction.ID(r),
 			}).Debug("Will serve a stale response")
 
-			rep = staleResponse(cached)
+			d.status = xCacheStale
+			rep = revalidationFailedResponse(cached)
 		}
-		return
+		return rep, false
 	}
 
 	if originChanged(r, rep) {
@@ -113,10 +166,13 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"id": transaction.ID(r),
 		}).Debug("Will serve a revalidated response")
 
+		d.status = xCacheRevalidated
 		rep = revalidatedResponse(rep, cached)
 	}
 
 	h.cacheIfPossible(&origReq, rep)
+
+	return rep, Cacheable(&origReq, rep)
 }
 
 func serveFresh(w io.Writer, cached *Representation, r *http.Request) {
@@ -138,6 +194,70 @@ func serveStale(w io.Writer, cached *Representation, r *http.Request) {
 	}
 }
 
+// revalidateInBackground revalidates the cached representation without blocking the request.
+// Only one revalidation runs at a time for each cached representation.
+func (h *Handler) revalidateInBackground(r *http.Request, cached *Representation) {
+	// cached representations from storages are clones without IDs. Identify them by the request instead.
+	key := revalidationKey{
+		ResourceKey:       NewResourceKey(r),
+		RepresentationKey: NewRepresentationKey(NewResource(r, cached), r),
+	}
+
+	h.revalidating.Lock()
+	if h.revalidating.keys == nil {
+		h.revalidating.keys = make(map[revalidationKey]struct{})
+	}
+	if _, ok := h.revalidating.keys[key]; ok {
+		h.revalidating.Unlock()
+		return
+	}
+	h.revalidating.keys[key] = struct{}{}
+	h.revalidating.Unlock()
+
+	// the revalidation outlives the request but keeps its transaction ID.
+	req := revalidateRequest(transaction.Detach(r), cached)
+	reqURL := *r.URL
+	req.URL = &reqURL
+
+	// Keep the original request since `balance.Handler` will modify the request.
+	origReq := *r
+	origURL := *r.URL
+	origReq.URL = &origURL
+
+	log.WithFields(log.Fields{
+		"id": transaction.ID(r),
+	}).Debug("Will revalidate a stale response in background")
+
+	go func() {
+		defer func() {
+			h.revalidating.Lock()
+			delete(h.revalidating.keys, key)
+			h.revalidating.Unlock()
+		}()
+
+		rep := NewRepresentation(h.Next, req)
+		if !rep.Successful() {
+			log.WithFields(log.Fields{
+				"id":     transaction.ID(req),
+				"status": rep.StatusCode,
+			}).Debug("Couldn't revalidate a stale response in background")
+
+			return
+		}
+
+		if revalidated(Revalidate, rep) {
+			rep = revalidatedResponse(rep, cached)
+		}
+
+		h.cacheIfPossible(&origReq, rep)
+
+		log.WithFields(log.Fields{
+			"id":     transaction.ID(req),
+			"status": rep.StatusCode,
+		}).Debug("Revalidated a stale response in background")
+	}()
+}
+
 func originChanged(req *http.Request, rep *Representation) bool {
 	return !idempotent(req) && successful(rep)
 }
@@ -169,7 +289,7 @@ func revalidateRequest(orig *http.Request, cached *Representation) *http.Request
 		req.Header.Set(ifModifiedSinceField, time)
 	}
 
-	return req
+	return req.WithContext(orig.Context())
 }
 
 func staleResponse(cached *Representation) *Representation {
@@ -177,6 +297,76 @@ func staleResponse(cached *Representation) *Representation {
 	return cached
 }
 
+// revalidationFailedResponse returns a copy of the cached representation with warnings.
+func revalidationFailedResponse(cached *Representation) *Representation {
+	cached.RLock()
+	defer cached.RUnlock()
+
+	rep := cached.clone()
+	rep.HeaderMap.Del(warningField)
+	if staleness(cached) > 0 {
+		rep.HeaderMap.Add(warningField, `110 - "Response is Stale"`)
+	}
+	rep.HeaderMap.Add(warningField, `111 - "Revalidation Failed"`)
+	return rep
+}
+
+// staleIfError returns true if the cached representation can be served in place of the error response.
+// stale-if-error directive in the request takes precedence over the one in the cached representation.
+// Without the directive, it falls back to stale representations only if they're otherwise acceptable.
+func staleIfError(req *http.Request, state CachedState, cached, rep *Representation) bool {
+	if cached == nil || !serverError(rep) {
+		return false
+	}
+
+	cached.RLock()
+	defer cached.RUnlock()
+
+	if contains(cached.HeaderMap, cacheControlField, mustRevalidatePattern) {
+		return false
+	}
+
+	if limit, ok := staleIfErrorLimit(req.Header); ok {
+		return staleness(cached) <= limit
+	}
+
+	if limit, ok := staleIfErrorLimit(cached.HeaderMap); ok {
+		return staleness(cached) <= limit
+	}
+
+	return state == Stale
+}
+
+// serverError returns true for errors described in RFC 5861 including transport errors reported as 502 or 504.
+func serverError(rep *Representation) bool {
+	switch rep.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func staleIfErrorLimit(h http.Header) (time.Duration, bool) {
+	matches := matches(h, cacheControlField, staleIfErrorPattern)
+	if matches == nil {
+		return time.Duration(0), false
+	}
+
+	s, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return time.Duration(0), false
+	}
+
+	return time.Duration(s) * time.Second, true
+}
+
+// staleness returns how long the cached representation has been stale. It's negative if it's still fresh.
+func staleness(cached *Representation) time.Duration {
+	lifetime, _ := freshnessLifetime(cached)
+	return currentAge(cached) - lifetime
+}
+
 func revalidatedResponse(rep *Representation, cached *Representation) *Representation {
 	var warnings []string
 	for _, warning := range values(cached.HeaderMap, warningField) {
@@ -207,6 +397,69 @@ func (h *Handler) cacheIfPossible(req *http.Request, rep *Representation) {
 	h.Set(req, rep)
 }
 
+// State returns the state of cached response.
+func (h *Handler) State(req *http.Request, cached *Representation) (CachedState, time.Duration) {
+	if cached == nil {
+		return Miss, time.Duration(0)
+	}
+
+	cached.RLock()
+	defer cached.RUnlock()
+
+	if contains(req.Header, pragmaField, noStore) {
+		return Revalidate, time.Duration(0)
+	}
+
+	if contains(req.Header, cacheControlField, noStore) {
+		return Revalidate, time.Duration(0)
+	}
+
+	if contains(cached.HeaderMap, cacheControlField, noStore) {
+		return Revalidate, time.Duration(0)
+	}
+
+	if lifetime, ok := freshnessLifetime(cached); ok {
+		age := currentAge(cached)
+
+		// cached responses before the last destructive requests (e.g. POST) are considered outdated.
+		if time.Since(h.OriginChangedAt) <= age {
+			return Revalidate, time.Duration(0)
+		}
+
+		delta := age - lifetime
+		if lifetime > age {
+			return Fresh, delta
+		}
+
+		// stale-while-revalidate allows a stale response even with s-maxage unless revalidation is a must.
+		if swr, ok := staleWhileRevalidate(cached); ok && delta <= swr && !contains(cached.HeaderMap, cacheControlField, mustRevalidatePattern) {
+			return Stale, delta
+		}
+
+		if contains(cached.HeaderMap, cacheControlField, revalidatePattern) {
+			return Revalidate, time.Duration(0)
+		}
+
+		return Stale, delta
+	}
+
+	return Revalidate, time.Duration(0)
+}
+
+// Age returns the current age of the cached representation.
+func Age(cached *Representation) time.Duration {
+	return currentAge(cached)
+}
+
+// IsFresh returns true if the cached representation is still fresh.
+func IsFresh(cached *Representation) bool {
+	cached.RLock()
+	defer cached.RUnlock()
+
+	lifetime, ok := freshnessLifetime(cached)
+	return ok && lifetime > currentAge(cached)
+}
+
 func freshnessLifetime(cached *Representation) (time.Duration, bool) {
 	if age, ok := sMaxage(cached); ok {
 		return age, true
@@ -298,6 +551,20 @@ func maxStale(cached *Representation) time.Duration {
 	return time.Duration(s) * time.Second
 }
 
+func staleWhileRevalidate(cached *Representation) (time.Duration, bool) {
+	matches := matches(cached.HeaderMap, cacheControlField, staleWhileRevalidatePattern)
+	if matches == nil {
+		return time.Duration(0), false
+	}
+
+	s, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return time.Duration(0), false
+	}
+
+	return time.Duration(s) * time.Second, true
+}
+
 func currentAge(cached *Representation) time.Duration {
 	return correctedInitialAge(cached) + residentTime(cached)
 }
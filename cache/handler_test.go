@@ -34,7 +34,7 @@ func TestHandler_ServeHTTP(t *testing.T) {
 						},
 					},
 				},
-				Store: &Store{},
+				Storage: &Store{},
 			},
 			req: &http.Request{
 				Method: http.MethodGet,
@@ -63,7 +63,7 @@ func TestHandler_ServeHTTP(t *testing.T) {
 						},
 					},
 				},
-				Store: &Store{},
+				Storage: &Store{},
 			},
 			req: &http.Request{
 				Method: http.MethodGet,
@@ -82,7 +82,7 @@ func TestHandler_ServeHTTP(t *testing.T) {
 		{ // fetch from store
 			handler: &Handler{
 				Next: &testHandler{},
-				Store: &Store{
+				Storage: &Store{
 					Resources: map[ResourceKey]*Resource{
 						{Host: "www.example.com", Path: "/test"}: {
 							Representations: map[RepresentationKey]*Representation{
@@ -150,6 +150,253 @@ func TestHandler_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestHandler_ServeHTTP_staleWhileRevalidate(t *testing.T) {
+	backend := &testHandler{
+		Resources: map[string]*Representation{
+			"http://www.example.com/test": {
+				StatusCode: http.StatusOK,
+				HeaderMap: http.Header{
+					"Cache-Control": []string{"max-age=60, stale-while-revalidate=60"},
+				},
+				Body: []byte(`{"foo":"new"}`),
+			},
+		},
+	}
+	h := Handler{
+		Next:    backend,
+		Storage: &Store{},
+	}
+	h.Set(testRequest("/test"), &Representation{
+		StatusCode: http.StatusOK,
+		HeaderMap: http.Header{
+			"Cache-Control": []string{"max-age=1, stale-while-revalidate=60"},
+		},
+		Body:         []byte(`{"foo":"old"}`),
+		RequestTime:  time.Now().Add(-3 * time.Second),
+		ResponseTime: time.Now().Add(-2 * time.Second),
+	})
+
+	req := testRequest("/test")
+	req.Header = http.Header{}
+
+	// the stale response is served immediately.
+	var rep Representation
+	h.ServeHTTP(&rep, req)
+
+	if string(rep.Body) != `{"foo":"old"}` {
+		t.Errorf(`expected {"foo":"old"}, got %s`, string(rep.Body))
+	}
+	if w := rep.HeaderMap.Get("Warning"); w != `110 - "Response is Stale"` {
+		t.Errorf(`expected 110 - "Response is Stale", got %s`, w)
+	}
+
+	// then the cache is updated in background.
+	deadline := time.Now().Add(time.Second)
+	for {
+		cached := h.Get(testRequest("/test"))
+		if cached != nil && string(cached.Body) == `{"foo":"new"}` {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected to be revalidated in background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var fresh Representation
+	h.ServeHTTP(&fresh, req)
+
+	if string(fresh.Body) != `{"foo":"new"}` {
+		t.Errorf(`expected {"foo":"new"}, got %s`, string(fresh.Body))
+	}
+}
+
+func TestHandler_ServeHTTP_staleWhileRevalidate_concurrent(t *testing.T) {
+	backend := &testHandler{
+		Resources: map[string]*Representation{
+			"http://www.example.com/a": {
+				StatusCode: http.StatusOK,
+				HeaderMap: http.Header{
+					"Cache-Control": []string{"max-age=60, stale-while-revalidate=60"},
+				},
+				Body: []byte(`{"a":"new"}`),
+			},
+			"http://www.example.com/b": {
+				StatusCode: http.StatusOK,
+				HeaderMap: http.Header{
+					"Cache-Control": []string{"max-age=60, stale-while-revalidate=60"},
+				},
+				Body: []byte(`{"b":"new"}`),
+			},
+		},
+	}
+
+	// the revalidation of /a blocks until released.
+	release := make(chan struct{})
+	h := Handler{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/a" {
+				<-release
+			}
+			backend.ServeHTTP(w, r)
+		}),
+		Storage: &Store{},
+	}
+	for _, p := range []string{"/a", "/b"} {
+		h.Set(testRequest(p), &Representation{
+			StatusCode: http.StatusOK,
+			HeaderMap: http.Header{
+				"Cache-Control": []string{"max-age=1, stale-while-revalidate=60"},
+			},
+			Body:         []byte(`{}`),
+			RequestTime:  time.Now().Add(-3 * time.Second),
+			ResponseTime: time.Now().Add(-2 * time.Second),
+		})
+	}
+
+	for _, p := range []string{"/a", "/b"} {
+		req := testRequest(p)
+		req.Header = http.Header{}
+
+		var rep Representation
+		h.ServeHTTP(&rep, req)
+
+		if string(rep.Body) != `{}` {
+			t.Errorf(`expected {}, got %s`, string(rep.Body))
+		}
+	}
+
+	// /b is revalidated while /a is still being revalidated.
+	deadline := time.Now().Add(time.Second)
+	for {
+		cached := h.Get(testRequest("/b"))
+		if cached != nil && string(cached.Body) == `{"b":"new"}` {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected /b to be revalidated in background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+
+	deadline = time.Now().Add(time.Second)
+	for {
+		cached := h.Get(testRequest("/a"))
+		if cached != nil && string(cached.Body) == `{"a":"new"}` {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected /a to be revalidated in background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandler_ServeHTTP_staleIfError(t *testing.T) {
+	testCases := []struct {
+		status       int
+		cacheControl string
+		reqHeader    http.Header
+
+		body     string
+		warnings []string
+	}{
+		{ // within stale-if-error of the response
+			status:       http.StatusServiceUnavailable,
+			cacheControl: "max-age=1, stale-if-error=60",
+			reqHeader:    http.Header{},
+			body:         `{"foo":"old"}`,
+			warnings:     []string{`110 - "Response is Stale"`, `111 - "Revalidation Failed"`},
+		},
+		{ // beyond stale-if-error of the response
+			status:       http.StatusServiceUnavailable,
+			cacheControl: "max-age=1, stale-if-error=1",
+			reqHeader:    http.Header{},
+			body:         `error`,
+		},
+		{ // stale-if-error of the request takes precedence
+			status:       http.StatusServiceUnavailable,
+			cacheControl: "max-age=1, stale-if-error=1",
+			reqHeader:    http.Header{"Cache-Control": []string{"stale-if-error=60"}},
+			body:         `{"foo":"old"}`,
+			warnings:     []string{`110 - "Response is Stale"`, `111 - "Revalidation Failed"`},
+		},
+		{ // stale-if-error works even if it requires revalidation with s-maxage
+			status:       http.StatusGatewayTimeout,
+			cacheControl: "s-maxage=1, stale-if-error=60",
+			reqHeader:    http.Header{},
+			body:         `{"foo":"old"}`,
+			warnings:     []string{`110 - "Response is Stale"`, `111 - "Revalidation Failed"`},
+		},
+		{ // but not with must-revalidate
+			status:       http.StatusBadGateway,
+			cacheControl: "max-age=1, stale-if-error=60, must-revalidate",
+			reqHeader:    http.Header{},
+			body:         `error`,
+		},
+		{ // without stale-if-error, it serves a stale response
+			status:       http.StatusBadGateway,
+			cacheControl: "max-age=1",
+			reqHeader:    http.Header{},
+			body:         `{"foo":"old"}`,
+			warnings:     []string{`110 - "Response is Stale"`, `111 - "Revalidation Failed"`},
+		},
+		{ // client errors aren't errors of the origin
+			status:       http.StatusNotFound,
+			cacheControl: "max-age=1, stale-if-error=60",
+			reqHeader:    http.Header{},
+			body:         `error`,
+		},
+	}
+
+	for i, tc := range testCases {
+		h := Handler{
+			Next: &testHandler{
+				Resources: map[string]*Representation{
+					"http://www.example.com/test": {
+						StatusCode: tc.status,
+						HeaderMap:  http.Header{},
+						Body:       []byte(`error`),
+					},
+				},
+			},
+			Storage: &Store{},
+		}
+		h.Set(testRequest("/test"), &Representation{
+			StatusCode: http.StatusOK,
+			HeaderMap: http.Header{
+				"Cache-Control": []string{tc.cacheControl},
+			},
+			Body:         []byte(`{"foo":"old"}`),
+			RequestTime:  time.Now().Add(-6 * time.Second),
+			ResponseTime: time.Now().Add(-5 * time.Second),
+		})
+
+		req := testRequest("/test")
+		req.Header = tc.reqHeader
+
+		var rep Representation
+		h.ServeHTTP(&rep, req)
+
+		if tc.body != string(rep.Body) {
+			t.Errorf("(%d) expected %s, got %s", i, tc.body, string(rep.Body))
+		}
+
+		warnings := rep.HeaderMap["Warning"]
+		if len(tc.warnings) != len(warnings) {
+			t.Errorf("(%d) expected %#v, got %#v", i, tc.warnings, warnings)
+			continue
+		}
+		for j, w := range tc.warnings {
+			if w != warnings[j] {
+				t.Errorf("(%d) expected %s, got %s", i, w, warnings[j])
+			}
+		}
+	}
+}
+
 func TestCacheable(t *testing.T) {
 	url, err := url.Parse("http://www.example.com/test")
 	if err != nil {
@@ -528,6 +775,57 @@ func TestHandler_State(t *testing.T) {
 			state: Stale,
 			delta: time.Duration(0),
 		},
+		{ // stale-while-revalidate allows a stale response with s-maxage
+			req: &http.Request{
+				URL:    url,
+				Header: http.Header{},
+			},
+			cached: &Representation{
+				HeaderMap: http.Header{
+					"Cache-Control": []string{"s-maxage=1, stale-while-revalidate=60"},
+				},
+				Body:         []byte{},
+				RequestTime:  now.Add(-3 * time.Second),
+				ResponseTime: now.Add(-2 * time.Second),
+			},
+
+			state: Stale,
+			delta: time.Duration(0),
+		},
+		{ // but not with must-revalidate
+			req: &http.Request{
+				URL:    url,
+				Header: http.Header{},
+			},
+			cached: &Representation{
+				HeaderMap: http.Header{
+					"Cache-Control": []string{"s-maxage=1, stale-while-revalidate=60, must-revalidate"},
+				},
+				Body:         []byte{},
+				RequestTime:  now.Add(-3 * time.Second),
+				ResponseTime: now.Add(-2 * time.Second),
+			},
+
+			state: Revalidate,
+			delta: time.Duration(0),
+		},
+		{ // nor beyond stale-while-revalidate
+			req: &http.Request{
+				URL:    url,
+				Header: http.Header{},
+			},
+			cached: &Representation{
+				HeaderMap: http.Header{
+					"Cache-Control": []string{"s-maxage=1, stale-while-revalidate=1"},
+				},
+				Body:         []byte{},
+				RequestTime:  now.Add(-4 * time.Second),
+				ResponseTime: now.Add(-3 * time.Second),
+			},
+
+			state: Revalidate,
+			delta: time.Duration(0),
+		},
 		{
 			req: &http.Request{
 				URL:    url,
@@ -546,7 +844,7 @@ func TestHandler_State(t *testing.T) {
 	}
 
 	for i, tc := range testCases {
-		h := Handler{Store: &Store{OriginChangedAt: tc.originChangedAt}}
+		h := Handler{OriginChangedAt: tc.originChangedAt}
 		s, d := h.State(tc.req, tc.cached)
 
 		if tc.state != s {
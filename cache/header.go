@@ -12,4 +12,6 @@ const (
 	ifNoneMatchField     = "If-None-Match"
 	lastModifiedField    = "Last-Modified"
 	ifModifiedSinceField = "If-Modified-Since"
+	surrogateKeyField    = "Surrogate-Key"
+	cacheTagField        = "Cache-Tag"
 )
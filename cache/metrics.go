@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"github.com/ichiban/jesi/metrics"
+)
+
+var (
+	requests  = metrics.NewCounter("jesi_cache_requests_total", "Number of requests by cached state.", "state")
+	collapsed = metrics.NewCounter("jesi_cache_collapsed_requests_total", "Number of requests served by fetches of other requests.")
+)
+
+// RegisterMetrics registers metrics of how much of the storage is used.
+func RegisterMetrics(s Storage) {
+	usage := func(f func(u Usage) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var ss []metrics.Sample
+			for _, u := range s.Usage() {
+				ss = append(ss, metrics.Sample{
+					Labels: []string{u.Tier},
+					Value:  f(u),
+				})
+			}
+			return ss
+		}
+	}
+
+	metrics.NewGaugeFunc("jesi_cache_in_use_bytes", "Bytes of cached representations.", usage(func(u Usage) float64 {
+		return float64(u.InUse)
+	}), "tier")
+	metrics.NewGaugeFunc("jesi_cache_max_bytes", "Maximum bytes of cached representations.", usage(func(u Usage) float64 {
+		return float64(u.Max)
+	}), "tier")
+	metrics.NewGaugeFunc("jesi_cache_representations", "Number of cached representations.", usage(func(u Usage) float64 {
+		return float64(u.Representations)
+	}), "tier")
+	metrics.NewCounterFunc("jesi_cache_hits_total", "Number of lookups which found a representation.", usage(func(u Usage) float64 {
+		return float64(u.Hits)
+	}), "tier")
+	metrics.NewCounterFunc("jesi_cache_misses_total", "Number of lookups which didn't find a representation.", usage(func(u Usage) float64 {
+		return float64(u.Misses)
+	}), "tier")
+	metrics.NewCounterFunc("jesi_cache_evictions_total", "Number of representations evicted to make room.", usage(func(u Usage) float64 {
+		return float64(u.Evictions)
+	}), "tier")
+}
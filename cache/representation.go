@@ -96,3 +96,17 @@ func (r *Representation) clone() *Representation {
 	c.Body = r.Body
 	return &c
 }
+
+// snapshot returns a copy of the representation without its body.
+func (r *Representation) snapshot() *Representation {
+	r.RLock()
+	defer r.RUnlock()
+
+	c := r.clone()
+	c.ResourceKey = r.ResourceKey
+	c.RepresentationKey = r.RepresentationKey
+	c.ID = r.ID
+	c.LastUsedTime = r.LastUsedTime
+	c.Body = nil
+	return c
+}
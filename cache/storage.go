@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"net/http"
+)
+
+// Storage stores pairs of request/response.
+type Storage interface {
+	// Get retrieves a cached response.
+	Get(req *http.Request) *Representation
+
+	// Set inserts/updates a new pair of request/response to the storage.
+	Set(req *http.Request, rep *Representation)
+
+	// Purge removes any representations associated to the request.
+	Purge(req *http.Request) *Resource
+
+	// PurgeTag removes any representations tagged with the given cache tag.
+	PurgeTag(tag string) []*Representation
+
+	// Ban removes any resources matching the given condition.
+	Ban(match func(ResourceKey) bool) []*Resource
+
+	// Each calls f for each cached representation with its size. The representation doesn't have its body.
+	Each(f func(res *Resource, rep *Representation, size uint64))
+
+	// Usage returns how much of the storage is used.
+	Usage() []Usage
+}
+
+// Usage represents how much of a storage is used.
+type Usage struct {
+	Tier            string `json:"tier"`
+	Resources       int    `json:"resources"`
+	Representations int    `json:"representations"`
+	InUse           uint64 `json:"in_use"`
+	Max             uint64 `json:"max"`
+	Hits            uint64 `json:"hits"`
+	Misses          uint64 `json:"misses"`
+	Evictions       uint64 `json:"evictions"`
+}
+
+var _ Storage = (*Store)(nil)
+var _ Storage = (*DiskStore)(nil)
+var _ Storage = (*TieredStore)(nil)
+var _ Storage = (*WatchedStorage)(nil)
@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/satori/go.uuid"
@@ -14,7 +15,7 @@ import (
 	"github.com/ichiban/jesi/transaction"
 )
 
-// Store stores pairs of request/response.
+// Store stores pairs of request/response in memory.
 type Store struct {
 	sync.RWMutex
 	Resources       map[ResourceKey]*Resource
@@ -22,13 +23,42 @@ type Store struct {
 	Max             uint64
 	InUse           uint64
 	Sample          uint
-	OriginChangedAt time.Time
+	Hits            uint64
+	Misses          uint64
+	Evictions       uint64
+
+	// Evicted is called for each representation evicted from the store if it's not nil.
+	Evicted func(res *Resource, rep *Representation)
+
+	tags tagIndex
 }
 
 // Set inserts/updates a new pair of request/response to the cache.
 func (s *Store) Set(req *http.Request, rep *Representation) {
 	s.init()
 
+	evicted := s.set(req, rep)
+
+	if s.Evicted == nil {
+		return
+	}
+
+	for _, e := range evicted {
+		s.Evicted(e.res, e.rep)
+	}
+}
+
+type eviction struct {
+	res *Resource
+	rep *Representation
+}
+
+func (s *Store) set(req *http.Request, rep *Representation) []eviction {
+	// revalidated representations are clones without IDs.
+	if uuid.Equal(rep.ID, uuid.Nil) {
+		rep.ID, _ = uuid.NewV4()
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
@@ -41,6 +71,8 @@ func (s *Store) Set(req *http.Request, rep *Representation) {
 
 	repKey := NewRepresentationKey(res, req)
 	if old, ok := res.Representations[repKey]; ok {
+		delete(s.Representations, old.ID)
+		s.tags.remove(old)
 		s.InUse -= uint64(len(old.Body))
 		log.WithFields(log.Fields{
 			"id": old.ID,
@@ -50,6 +82,7 @@ func (s *Store) Set(req *http.Request, rep *Representation) {
 	rep.ResourceKey = resKey
 	rep.RepresentationKey = repKey
 	s.Representations[rep.ID] = rep
+	s.tags.add(rep)
 	rep.LastUsedTime = time.Now()
 
 	log.WithFields(log.Fields{
@@ -60,15 +93,17 @@ func (s *Store) Set(req *http.Request, rep *Representation) {
 	s.InUse += uint64(len(rep.Body))
 
 	if s.Max == 0 {
-		return
+		return nil
 	}
 
+	var evicted []eviction
 	for s.InUse > s.Max {
-		s.evict()
+		evicted = append(evicted, s.evict())
 	}
+	return evicted
 }
 
-func (s *Store) evict() {
+func (s *Store) evict() eviction {
 	var minID uuid.UUID
 	var minRep *Representation
 	i := s.Sample
@@ -94,11 +129,15 @@ func (s *Store) evict() {
 	}
 
 	delete(s.Representations, minID)
+	s.tags.remove(minRep)
 	s.InUse -= uint64(len(minRep.Body))
+	s.Evictions++
 
 	log.WithFields(log.Fields{
 		"id": minRep.ID,
 	}).Info("Removed a representation")
+
+	return eviction{res: res, rep: minRep}
 }
 
 // Get retrieves a cached response.
@@ -111,15 +150,19 @@ func (s *Store) Get(req *http.Request) *Representation {
 	resKey := NewResourceKey(req)
 	res, ok := s.Resources[resKey]
 	if !ok {
+		atomic.AddUint64(&s.Misses, 1)
 		return nil
 	}
 
 	repKey := NewRepresentationKey(res, req)
 	rep, ok := res.Representations[repKey]
 	if !ok {
+		atomic.AddUint64(&s.Misses, 1)
 		return nil
 	}
 
+	atomic.AddUint64(&s.Hits, 1)
+
 	rep.Lock()
 	defer rep.Unlock()
 	rep.LastUsedTime = time.Now()
@@ -143,21 +186,113 @@ func (s *Store) Purge(req *http.Request) *Resource {
 	if !ok {
 		return nil
 	}
+	s.purge(resKey, res)
+
+	return res
+}
+
+// Ban removes any resources matching the given condition.
+func (s *Store) Ban(match func(ResourceKey) bool) []*Resource {
+	s.init()
+
+	s.Lock()
+	defer s.Unlock()
+
+	var ress []*Resource
+	for resKey, res := range s.Resources {
+		if !match(resKey) {
+			continue
+		}
+		s.purge(resKey, res)
+		ress = append(ress, res)
+	}
+
+	return ress
+}
+
+func (s *Store) purge(resKey ResourceKey, res *Resource) {
 	delete(s.Resources, resKey)
 
-	for repKey := range res.Representations {
-		rep, ok := res.Representations[repKey]
+	for _, rep := range res.Representations {
+		delete(s.Representations, rep.ID)
+		s.tags.remove(rep)
+		s.InUse -= uint64(len(rep.Body))
+
+		log.WithFields(log.Fields{
+			"id": rep.ID,
+		}).Info("Removed a representation")
+	}
+}
+
+// PurgeTag removes any representations tagged with the given cache tag.
+func (s *Store) PurgeTag(tag string) []*Representation {
+	s.init()
+
+	s.Lock()
+	defer s.Unlock()
+
+	var reps []*Representation
+	for id := range s.tags[tag] {
+		rep, ok := s.Representations[id]
 		if !ok {
 			continue
 		}
+
+		if res, ok := s.Resources[rep.ResourceKey]; ok {
+			delete(res.Representations, rep.RepresentationKey)
+			if len(res.Representations) == 0 {
+				delete(s.Resources, res.ResourceKey)
+			}
+		}
+
+		delete(s.Representations, id)
+		s.tags.remove(rep)
 		s.InUse -= uint64(len(rep.Body))
 
 		log.WithFields(log.Fields{
-			"id": rep.ID,
+			"id":  rep.ID,
+			"tag": tag,
 		}).Info("Removed a representation")
+
+		reps = append(reps, rep)
 	}
 
-	return res
+	return reps
+}
+
+// Each calls f for each cached representation with its size. The representation doesn't have its body.
+func (s *Store) Each(f func(res *Resource, rep *Representation, size uint64)) {
+	s.init()
+
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, res := range s.Resources {
+		for _, rep := range res.Representations {
+			f(res, rep.snapshot(), uint64(len(rep.Body)))
+		}
+	}
+}
+
+// Usage returns how much of the store is used.
+func (s *Store) Usage() []Usage {
+	s.init()
+
+	s.RLock()
+	defer s.RUnlock()
+
+	return []Usage{
+		{
+			Tier:            "memory",
+			Resources:       len(s.Resources),
+			Representations: len(s.Representations),
+			InUse:           s.InUse,
+			Max:             s.Max,
+			Hits:            atomic.LoadUint64(&s.Hits),
+			Misses:          atomic.LoadUint64(&s.Misses),
+			Evictions:       s.Evictions,
+		},
+	}
 }
 
 func (s *Store) init() {
@@ -175,6 +310,12 @@ func (s *Store) init() {
 			}
 		}
 	}
+	if s.tags == nil {
+		s.tags = make(tagIndex)
+		for _, rep := range s.Representations {
+			s.tags.add(rep)
+		}
+	}
 }
 
 // ResourceKey identifies a resource.
@@ -278,50 +419,6 @@ const (
 	Revalidate
 )
 
-// State returns the state of cached response.
-func (s *Store) State(req *http.Request, cached *Representation) (CachedState, time.Duration) {
-	if cached == nil {
-		return Miss, time.Duration(0)
-	}
-
-	cached.RLock()
-	defer cached.RUnlock()
-
-	if contains(req.Header, pragmaField, noStore) {
-		return Revalidate, time.Duration(0)
-	}
-
-	if contains(req.Header, cacheControlField, noStore) {
-		return Revalidate, time.Duration(0)
-	}
-
-	if contains(cached.HeaderMap, cacheControlField, noStore) {
-		return Revalidate, time.Duration(0)
-	}
-
-	if lifetime, ok := freshnessLifetime(cached); ok {
-		age := currentAge(cached)
-
-		// cached responses before the last destructive requests (e.g. POST) are considered outdated.
-		if time.Since(s.OriginChangedAt) <= age {
-			return Revalidate, time.Duration(0)
-		}
-
-		delta := age - lifetime
-		if lifetime > age {
-			return Fresh, delta
-		}
-
-		if contains(cached.HeaderMap, cacheControlField, revalidatePattern) {
-			return Revalidate, time.Duration(0)
-		}
-
-		return Stale, delta
-	}
-
-	return Revalidate, time.Duration(0)
-}
-
 func (s CachedState) String() string {
 	switch s {
 	case Miss:
@@ -447,6 +447,48 @@ func TestStore_Purge(t *testing.T) {
 	}
 }
 
+func TestStore_PurgeTag(t *testing.T) {
+	store := Store{}
+	store.Set(testRequest("/foo"), &Representation{
+		HeaderMap: http.Header{"Surrogate-Key": []string{"movie-1 foo"}},
+		Body:      []byte(`{"foo":"ok"}`),
+	})
+	store.Set(testRequest("/bar"), &Representation{
+		HeaderMap: http.Header{"Cache-Tag": []string{"movie-1,bar"}},
+		Body:      []byte(`{"bar":"ok"}`),
+	})
+	store.Set(testRequest("/baz"), &Representation{
+		HeaderMap: http.Header{"Surrogate-Key": []string{"baz"}},
+		Body:      []byte(`{"baz":"ok"}`),
+	})
+
+	if reps := store.PurgeTag("unknown"); len(reps) != 0 {
+		t.Errorf("expected 0, got %d", len(reps))
+	}
+
+	if reps := store.PurgeTag("movie-1"); len(reps) != 2 {
+		t.Errorf("expected 2, got %d", len(reps))
+	}
+
+	for _, path := range []string{"/foo", "/bar"} {
+		if rep := store.Get(testRequest(path)); rep != nil {
+			t.Errorf("[%s] expected nil, got %#v", path, rep)
+		}
+	}
+
+	if rep := store.Get(testRequest("/baz")); rep == nil {
+		t.Error("expected non-nil, got nil")
+	}
+
+	if store.InUse != 12 {
+		t.Errorf("expected 12, got %d", store.InUse)
+	}
+
+	if reps := store.PurgeTag("foo"); len(reps) != 0 {
+		t.Errorf("expected 0, got %d", len(reps))
+	}
+}
+
 func BenchmarkStore_Get(b *testing.B) {
 	store := Store{
 		Resources: map[ResourceKey]*Resource{
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/satori/go.uuid"
+)
+
+// Tags returns cache tags of a response in Surrogate-Key (space separated) and Cache-Tag (comma separated) header fields.
+func Tags(h http.Header) []string {
+	var tags []string
+	seen := map[string]bool{}
+	add := func(t string) {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			return
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+
+	for _, v := range h[surrogateKeyField] {
+		for _, t := range strings.Fields(v) {
+			add(t)
+		}
+	}
+
+	for _, v := range h[cacheTagField] {
+		for _, t := range strings.Split(v, ",") {
+			add(t)
+		}
+	}
+
+	return tags
+}
+
+// SetTags sets cache tags to both Surrogate-Key and Cache-Tag header fields.
+func SetTags(h http.Header, tags []string) {
+	if len(tags) == 0 {
+		delete(h, surrogateKeyField)
+		delete(h, cacheTagField)
+		return
+	}
+
+	h.Set(surrogateKeyField, strings.Join(tags, " "))
+	h.Set(cacheTagField, strings.Join(tags, ","))
+}
+
+// tagIndex maps cache tags to IDs of representations tagged with them.
+type tagIndex map[string]map[uuid.UUID]struct{}
+
+func (i tagIndex) add(rep *Representation) {
+	for _, t := range Tags(rep.HeaderMap) {
+		ids, ok := i[t]
+		if !ok {
+			ids = make(map[uuid.UUID]struct{})
+			i[t] = ids
+		}
+		ids[rep.ID] = struct{}{}
+	}
+}
+
+func (i tagIndex) remove(rep *Representation) {
+	for _, t := range Tags(rep.HeaderMap) {
+		ids, ok := i[t]
+		if !ok {
+			continue
+		}
+		delete(ids, rep.ID)
+		if len(ids) == 0 {
+			delete(i, t)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	testCases := []struct {
+		header http.Header
+		tags   []string
+	}{
+		{
+			header: http.Header{},
+			tags:   nil,
+		},
+		{
+			header: http.Header{
+				"Surrogate-Key": []string{"foo  bar", "baz"},
+			},
+			tags: []string{"foo", "bar", "baz"},
+		},
+		{
+			header: http.Header{
+				"Cache-Tag": []string{"foo, bar", "baz,"},
+			},
+			tags: []string{"foo", "bar", "baz"},
+		},
+		{
+			header: http.Header{
+				"Surrogate-Key": []string{"foo bar"},
+				"Cache-Tag":     []string{"bar,baz"},
+			},
+			tags: []string{"foo", "bar", "baz"},
+		},
+	}
+
+	for i, tc := range testCases {
+		tags := Tags(tc.header)
+		if len(tc.tags) != len(tags) {
+			t.Errorf("(%d) expected: %#v, got: %#v", i, tc.tags, tags)
+			continue
+		}
+		for j := range tc.tags {
+			if tc.tags[j] != tags[j] {
+				t.Errorf("(%d, %d) expected: %s, got: %s", i, j, tc.tags[j], tags[j])
+			}
+		}
+	}
+}
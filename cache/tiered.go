@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TieredStore stores pairs of request/response in memory and on disk.
+// Representations evicted from Memory are demoted to Disk and promoted back to Memory when they're requested.
+type TieredStore struct {
+	Memory *Store
+	Disk   *DiskStore
+
+	once sync.Once
+}
+
+// Set inserts/updates a new pair of request/response to the cache.
+func (t *TieredStore) Set(req *http.Request, rep *Representation) {
+	t.init()
+
+	t.Memory.Set(req, rep)
+}
+
+// Get retrieves a cached response from Memory or Disk.
+func (t *TieredStore) Get(req *http.Request) *Representation {
+	t.init()
+
+	if rep := t.Memory.Get(req); rep != nil {
+		return rep
+	}
+
+	rep := t.Disk.take(req)
+	if rep == nil {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"id": rep.ID,
+	}).Info("Promoted a representation")
+
+	c := rep.clone()
+	c.ID = rep.ID
+	t.Memory.Set(req, c)
+
+	return rep
+}
+
+// Purge removes any representations associated to the request from both Memory and Disk.
+func (t *TieredStore) Purge(req *http.Request) *Resource {
+	t.init()
+
+	m := t.Memory.Purge(req)
+	d := t.Disk.Purge(req)
+
+	if m == nil {
+		return d
+	}
+
+	if d != nil {
+		for k, rep := range d.Representations {
+			if _, ok := m.Representations[k]; !ok {
+				m.Representations[k] = rep
+			}
+		}
+	}
+
+	return m
+}
+
+// PurgeTag removes any representations tagged with the given cache tag from both Memory and Disk.
+func (t *TieredStore) PurgeTag(tag string) []*Representation {
+	t.init()
+
+	return append(t.Memory.PurgeTag(tag), t.Disk.PurgeTag(tag)...)
+}
+
+// Ban removes any resources matching the given condition from both Memory and Disk.
+func (t *TieredStore) Ban(match func(ResourceKey) bool) []*Resource {
+	t.init()
+
+	return append(t.Memory.Ban(match), t.Disk.Ban(match)...)
+}
+
+// Each calls f for each cached representation in Memory and Disk with its size.
+func (t *TieredStore) Each(f func(res *Resource, rep *Representation, size uint64)) {
+	t.init()
+
+	t.Memory.Each(f)
+	t.Disk.Each(f)
+}
+
+// Usage returns how much of Memory and Disk are used.
+func (t *TieredStore) Usage() []Usage {
+	t.init()
+
+	return append(t.Memory.Usage(), t.Disk.Usage()...)
+}
+
+func (t *TieredStore) init() {
+	t.once.Do(func() {
+		t.Memory.Evicted = t.Disk.demote
+	})
+}
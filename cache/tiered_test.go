@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTieredStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jesi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := TieredStore{
+		Memory: &Store{Max: 13, Sample: 2},
+		Disk:   &DiskStore{Dir: dir, Sample: 2},
+	}
+
+	store.Set(testRequest("/foo"), &Representation{Body: []byte(`{"foo":"bar"}`)})
+	store.Set(testRequest("/test"), &Representation{Body: []byte(`{"test":"ok"}`)})
+
+	// /foo is evicted from memory and demoted to disk.
+	if store.Memory.InUse != 13 {
+		t.Errorf("[Memory.InUse] expected 13, got %d", store.Memory.InUse)
+	}
+	if store.Disk.InUse != 13 {
+		t.Errorf("[Disk.InUse] expected 13, got %d", store.Disk.InUse)
+	}
+
+	// /foo is promoted to memory and /test is demoted to disk.
+	rep := store.Get(testRequest("/foo"))
+	if rep == nil {
+		t.Fatal("expected non-nil, got nil")
+	}
+	if string(rep.Body) != `{"foo":"bar"}` {
+		t.Errorf(`expected {"foo":"bar"}, got %s`, string(rep.Body))
+	}
+	if rep := store.Memory.Get(testRequest("/foo")); rep == nil {
+		t.Error("expected to be promoted, got nil")
+	}
+	if rep := store.Disk.Get(testRequest("/test")); rep == nil {
+		t.Error("expected to be demoted, got nil")
+	}
+
+	if store.Memory.Hits != 1 || store.Memory.Misses != 1 {
+		t.Errorf("[Memory] expected 1 hit and 1 miss, got %d hits and %d misses", store.Memory.Hits, store.Memory.Misses)
+	}
+	if store.Disk.Hits != 2 || store.Disk.Misses != 0 {
+		t.Errorf("[Disk] expected 2 hits and 0 misses, got %d hits and %d misses", store.Disk.Hits, store.Disk.Misses)
+	}
+
+	if res := store.Purge(testRequest("/test")); res == nil {
+		t.Error("expected non-nil, got nil")
+	}
+	if rep := store.Get(testRequest("/test")); rep != nil {
+		t.Errorf("expected nil, got %#v", rep)
+	}
+}
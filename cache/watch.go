@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"net/http"
+)
+
+// WatchedStorage is a storage which reports resources replaced or purged.
+type WatchedStorage struct {
+	Storage
+
+	// Changed is called with the key of each resource replaced or purged.
+	Changed func(key ResourceKey)
+}
+
+// Set inserts/updates a new pair of request/response to the storage and reports the resource.
+func (s *WatchedStorage) Set(req *http.Request, rep *Representation) {
+	s.Storage.Set(req, rep)
+	s.changed(NewResourceKey(req))
+}
+
+// Purge removes any representations associated to the request and reports the resource.
+func (s *WatchedStorage) Purge(req *http.Request) *Resource {
+	res := s.Storage.Purge(req)
+	if res != nil {
+		s.changed(res.ResourceKey)
+	}
+	return res
+}
+
+// PurgeTag removes any representations tagged with the given cache tag and reports their resources.
+func (s *WatchedStorage) PurgeTag(tag string) []*Representation {
+	reps := s.Storage.PurgeTag(tag)
+	for _, rep := range reps {
+		s.changed(rep.ResourceKey)
+	}
+	return reps
+}
+
+// Ban removes any resources matching the given condition and reports them.
+func (s *WatchedStorage) Ban(match func(ResourceKey) bool) []*Resource {
+	ress := s.Storage.Ban(match)
+	for _, res := range ress {
+		s.changed(res.ResourceKey)
+	}
+	return ress
+}
+
+func (s *WatchedStorage) changed(key ResourceKey) {
+	if s.Changed == nil {
+		return
+	}
+	s.Changed(key)
+}
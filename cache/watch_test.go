@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestWatchedStorage(t *testing.T) {
+	var changed []ResourceKey
+	s := &WatchedStorage{
+		Storage: &Store{},
+		Changed: func(key ResourceKey) {
+			changed = append(changed, key)
+		},
+	}
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Host: "www.example.com", Path: "/test"},
+	}
+	key := ResourceKey{Host: "www.example.com", Path: "/test"}
+
+	s.Set(req, &Representation{
+		StatusCode: http.StatusOK,
+		HeaderMap:  http.Header{"Surrogate-Key": []string{"foo"}},
+	})
+	s.PurgeTag("foo")
+	s.Set(req, &Representation{StatusCode: http.StatusOK})
+	s.Purge(req)
+	s.Set(req, &Representation{StatusCode: http.StatusOK})
+	s.Ban(func(ResourceKey) bool { return true })
+	s.Purge(req)
+
+	expected := []ResourceKey{key, key, key, key, key, key}
+	if len(expected) != len(changed) {
+		t.Fatalf("expected %v, got %v", expected, changed)
+	}
+	for i := range expected {
+		if expected[i] != changed[i] {
+			t.Errorf("(%d) expected %v, got %v", i, expected[i], changed[i])
+		}
+	}
+}
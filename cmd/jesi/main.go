@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"net/http"
 	_ "net/http/pprof"
+	"sort"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/ichiban/jesi/admin"
 	"github.com/ichiban/jesi/balance"
 	"github.com/ichiban/jesi/cache"
 	"github.com/ichiban/jesi/conditional"
 	"github.com/ichiban/jesi/embed"
 	"github.com/ichiban/jesi/forward"
+	"github.com/ichiban/jesi/metrics"
 	"github.com/ichiban/jesi/transaction"
 )
 
@@ -23,15 +28,47 @@ func main() {
 	var proxy ReverseProxy
 	var node balance.Node
 	var backends balance.BackendPool
-	var store cache.Store
+	var max uint64
+	var sample uint
+	var dir string
+	var diskMax uint64
+	var adminAddr string
+	var adminToken string
+	var metricsAddr string
+	var diagnostics cache.Diagnostics
+	var limits embed.Limits
+	var jsonAPI bool
+	var fields bool
+	var stream bool
+	var compositeMax uint64
+	var hosts embedHosts
 	var verbose bool
 
 	flag.StringVar(&profile, "profile", "", "run debug profiler")
 	flag.IntVar(&proxy.Port, "port", 8080, "port number")
 	flag.Var(&node, "node", "node identifier (e.g. _jesi)")
 	flag.Var(&backends, "backend", "backend servers")
-	flag.Uint64Var(&store.Max, "max", 64*1024*1024, "max cache size in bytes")
-	flag.UintVar(&store.Sample, "sample", 3, "sample size for cache eviction")
+	flag.Uint64Var(&max, "max", 64*1024*1024, "max cache size in bytes")
+	flag.UintVar(&sample, "sample", 3, "sample size for cache eviction")
+	flag.StringVar(&dir, "dir", "", "directory to store cached representations evicted from memory")
+	flag.Uint64Var(&diskMax, "disk-max", 1024*1024*1024, "max on-disk cache size in bytes")
+	flag.StringVar(&adminAddr, "admin", "", "run admin API (e.g. localhost:8081)")
+	flag.StringVar(&adminToken, "admin-token", "", "bearer token for admin API")
+	flag.StringVar(&metricsAddr, "metrics", "", "expose Prometheus metrics at /metrics (e.g. localhost:9090)")
+	flag.BoolVar(&diagnostics.Always, "diagnostics", false, "add X-Cache and Server-Timing header fields to every response")
+	flag.StringVar(&diagnostics.Token, "diagnostics-token", "", "add X-Cache and Server-Timing header fields to responses to requests with Jesi-Diagnostics: <token>")
+	flag.IntVar(&limits.MaxDepth, "embed-max-depth", 8, "max depth of embedding edges (0 for unlimited)")
+	flag.IntVar(&limits.MaxSubrequests, "embed-max-subrequests", 128, "max subrequests for embedding per request (0 for unlimited)")
+	flag.IntVar(&limits.MaxConcurrency, "embed-concurrency", 16, "max concurrent subrequests for embedding per request (0 for unlimited)")
+	flag.IntVar(&limits.MaxPages, "embed-max-pages", 10, "max pages to follow for paginated embedding edges (0 for unlimited)")
+	flag.IntVar(&limits.MaxSize, "embed-max-size", 8*1024*1024, "max size of embedded documents in bytes (0 for unlimited)")
+	flag.DurationVar(&limits.SubrequestTimeout, "embed-subrequest-timeout", 10*time.Second, "timeout of each subrequest for embedding (0 for unlimited)")
+	flag.DurationVar(&limits.Timeout, "embed-timeout", 30*time.Second, "timeout of embedding per request (0 for unlimited)")
+	flag.BoolVar(&jsonAPI, "jsonapi", false, "embed related resources of JSON:API documents with include query parameter")
+	flag.BoolVar(&fields, "embed-fields", false, "select properties of HAL+JSON documents with fields query parameter")
+	flag.BoolVar(&stream, "embed-stream", false, "stream HAL+JSON documents while fetching embedded documents")
+	flag.Uint64Var(&compositeMax, "composite-max", 0, "max size in bytes of the cache of composed documents (0 for disabled)")
+	flag.Var(&hosts, "embed-host", "other host allowed to embed documents from, optionally with its backend server (e.g. api.example.com=http://10.0.0.1:8080)")
 	flag.BoolVar(&verbose, "verbose", false, "log extra information")
 	flag.Parse()
 
@@ -53,28 +90,122 @@ func main() {
 		log.SetLevel(log.DebugLevel)
 	}
 
+	memory := &cache.Store{Max: max, Sample: sample}
+	var storage cache.Storage = memory
+	if dir != "" {
+		disk := &cache.DiskStore{Dir: dir, Max: diskMax, Sample: sample}
+		if err := disk.Load(); err != nil {
+			log.WithFields(log.Fields{
+				"dir":   dir,
+				"error": err,
+			}).Fatal("Couldn't load the cache")
+		}
+		storage = &cache.TieredStore{Memory: memory, Disk: disk}
+	}
+
+	var composites *embed.CompositeCache
+	if compositeMax > 0 {
+		composites = &embed.CompositeCache{
+			Storage: &cache.Store{Max: compositeMax, Sample: sample},
+		}
+		storage = &cache.WatchedStorage{
+			Storage: storage,
+			Changed: composites.Changed,
+		}
+	}
+
+	cacheHandler := &cache.Handler{
+		Storage:     storage,
+		Diagnostics: &diagnostics,
+	}
+	if composites != nil {
+		composites.Cache = cacheHandler
+	}
+
+	if adminAddr != "" {
+		if adminToken == "" {
+			log.WithFields(log.Fields{
+				"host": adminAddr,
+			}).Fatal("Admin API requires a bearer token")
+		}
+
+		go func() {
+			log.WithFields(log.Fields{
+				"host": adminAddr,
+			}).Info("Start an admin API")
+			if err := http.ListenAndServe(adminAddr, &admin.Handler{
+				Cache: cacheHandler,
+				Token: adminToken,
+			}); err != nil {
+				log.WithFields(log.Fields{
+					"host":  adminAddr,
+					"error": err,
+				}).Error("Failed to run an admin API")
+			}
+		}()
+	}
+
+	if metricsAddr != "" {
+		cache.RegisterMetrics(storage)
+		balance.RegisterMetrics(&backends)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", &metrics.Handler{})
+
+		go func() {
+			log.WithFields(log.Fields{
+				"host": metricsAddr,
+			}).Info("Start exposing metrics")
+			if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+				log.WithFields(log.Fields{
+					"host":  metricsAddr,
+					"error": err,
+				}).Error("Failed to expose metrics")
+			}
+		}()
+	}
+
 	go backends.Run(nil)
+	for _, p := range hosts {
+		if p != nil {
+			go p.Run(nil)
+		}
+	}
 
 	log.WithFields(log.Fields{
 		"version": version,
 		"port":    proxy.Port,
 		"node":    &node,
-		"max":     store.Max,
+		"max":     max,
+		"dir":     dir,
+		"diskMax": diskMax,
 		"verbose": verbose,
 	}).Info("Start a server")
 
 	proxy.Node = &node
 	proxy.Backends = &backends
-	proxy.Store = &store
+	proxy.Cache = cacheHandler
+	proxy.Limits = limits
+	proxy.JSONAPI = jsonAPI
+	proxy.Fields = fields
+	proxy.Stream = stream
+	proxy.Composites = composites
+	proxy.Hosts = hosts
 	proxy.Run()
 }
 
 // ReverseProxy handles requests from downstream.
 type ReverseProxy struct {
-	Node     *balance.Node
-	Port     int
-	Backends *balance.BackendPool
-	Store    *cache.Store
+	Node       *balance.Node
+	Port       int
+	Backends   *balance.BackendPool
+	Cache      *cache.Handler
+	Limits     embed.Limits
+	JSONAPI    bool
+	Fields     bool
+	Stream     bool
+	Composites *embed.CompositeCache
+	Hosts      embedHosts
 }
 
 // Run runs the reverse proxy.
@@ -92,16 +223,21 @@ func (p *ReverseProxy) Run() {
 		BackendPool: p.Backends,
 		Next:        handler,
 	}
-	handler = &cache.Handler{
-		Next:  handler,
-		Store: p.Store,
-	}
+	p.Cache.Next = handler
+	handler = p.Cache
 	handler = &transaction.Handler{
 		Type: "internal",
 		Next: handler,
 	}
 	handler = &embed.Handler{
-		Next: handler,
+		Next:        handler,
+		Diagnostics: p.Cache.Diagnostics,
+		Limits:      p.Limits,
+		JSONAPI:     p.JSONAPI,
+		Fields:      p.Fields,
+		Stream:      p.Stream,
+		Composites:  p.Composites,
+		Hosts:       p.upstreams(),
 	}
 	handler = &conditional.Handler{
 		Next: handler,
@@ -122,6 +258,100 @@ func (p *ReverseProxy) Run() {
 	}
 }
 
+// upstreams returns handlers for subrequests to the other hosts.
+// Hosts without their own backends share the handler with the requests from downstream.
+func (p *ReverseProxy) upstreams() map[string]http.Handler {
+	if len(p.Hosts) == 0 {
+		return nil
+	}
+
+	upstreams := make(map[string]http.Handler, len(p.Hosts))
+	for host, backends := range p.Hosts {
+		if backends == nil {
+			upstreams[host] = nil
+			continue
+		}
+
+		var handler http.Handler
+		handler = &forward.Handler{
+			Transport: http.DefaultTransport,
+		}
+		handler = &transaction.Handler{
+			Type: "up",
+			Next: handler,
+		}
+		handler = &balance.Handler{
+			Node:        p.Node,
+			BackendPool: backends,
+			Next:        handler,
+		}
+		handler = &cache.Handler{
+			Storage:     p.Cache.Storage,
+			Diagnostics: p.Cache.Diagnostics,
+			Next:        handler,
+		}
+		handler = &transaction.Handler{
+			Type: "internal",
+			Next: handler,
+		}
+		upstreams[host] = handler
+	}
+	return upstreams
+}
+
+// embedHosts is a set of other hosts allowed to embed documents from.
+// Each host may have its own backends, otherwise subrequests are sent to the backends for the downstream requests.
+type embedHosts map[string]*balance.BackendPool
+
+var _ flag.Value = (*embedHosts)(nil)
+
+func (h *embedHosts) String() string {
+	if h == nil {
+		return ""
+	}
+
+	var s []string
+	for host, p := range *h {
+		if p == nil {
+			s = append(s, host)
+			continue
+		}
+		s = append(s, fmt.Sprintf("%s=%s", host, p))
+	}
+	sort.Strings(s)
+	return strings.Join(s, ", ")
+}
+
+// Set adds a host represented by the given string such as `api.example.com` or `api.example.com=http://10.0.0.1:8080`.
+func (h *embedHosts) Set(str string) error {
+	if *h == nil {
+		*h = embedHosts{}
+	}
+
+	host, backend := str, ""
+	if i := strings.IndexByte(str, '='); i >= 0 {
+		host, backend = str[:i], str[i+1:]
+	}
+
+	if host == "" {
+		return fmt.Errorf("no host: %s", str)
+	}
+
+	if backend == "" {
+		if _, ok := (*h)[host]; !ok {
+			(*h)[host] = nil
+		}
+		return nil
+	}
+
+	p := (*h)[host]
+	if p == nil {
+		p = &balance.BackendPool{}
+		(*h)[host] = p
+	}
+	return p.Set(backend)
+}
+
 func normalizeURL(h http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.TLS == nil {
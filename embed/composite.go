@@ -0,0 +1,281 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+// CompositeCache caches composed documents keyed by URL and normalized specs so that they don't have to be composed again.
+// A composed document is purged when any of its parts is replaced or purged.
+type CompositeCache struct {
+	cache.Storage
+
+	// Cache is the caching handler for the parts. Composed documents requested before the last change of the origin
+	// through it (e.g. POST) are outdated as well as the parts.
+	Cache *cache.Handler
+
+	sync.Mutex
+	dependents map[cache.ResourceKey]map[cache.ResourceKey]struct{} // composed documents of each part.
+	parts      map[cache.ResourceKey][]cache.ResourceKey            // parts of each composed document.
+	evictions  uint64
+	seq        uint64                    // sequence number of the last change.
+	composing  map[*composition]struct{} // documents being composed.
+}
+
+// Changed purges composed documents which consist of the resource.
+func (c *CompositeCache) Changed(key cache.ResourceKey) {
+	c.Lock()
+	c.seq++
+	for comp := range c.composing {
+		comp.changes[key] = c.seq
+	}
+	ds := make(map[cache.ResourceKey]struct{}, len(c.dependents[key]))
+	for d := range c.dependents[key] {
+		ds[d] = struct{}{}
+		c.forget(d)
+	}
+	c.Unlock()
+
+	if len(ds) == 0 {
+		return
+	}
+
+	c.Ban(func(k cache.ResourceKey) bool {
+		_, ok := ds[k]
+		return ok
+	})
+}
+
+// lookup returns a fresh composed document for the request if any.
+func (c *CompositeCache) lookup(req *http.Request) *cache.Representation {
+	if c == nil || strings.Contains(req.Header.Get(cacheControlField), noCacheDirective) {
+		return nil
+	}
+
+	cached := c.Get(req)
+	if cached == nil || !cache.IsFresh(cached) {
+		return nil
+	}
+
+	if c.Cache != nil && !cached.RequestTime.After(c.Cache.OriginChangedAt) {
+		return nil
+	}
+
+	return cached
+}
+
+// composition keeps track of the parts of a document being composed and changes of them.
+type composition struct {
+	cache   *CompositeCache
+	reads   map[cache.ResourceKey]uint64 // sequence numbers of the last changes when the parts were read.
+	changes map[cache.ResourceKey]uint64 // sequence numbers of the last changes of the resources.
+}
+
+// begin starts keeping track of changes for a document being composed.
+// It returns nil if the composite cache is nil.
+func (c *CompositeCache) begin() *composition {
+	if c == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.composing == nil {
+		c.composing = make(map[*composition]struct{})
+	}
+	comp := &composition{
+		cache:   c,
+		reads:   make(map[cache.ResourceKey]uint64),
+		changes: make(map[cache.ResourceKey]uint64),
+	}
+	c.composing[comp] = struct{}{}
+	return comp
+}
+
+// end stops keeping track of changes for the composed document.
+func (c *CompositeCache) end(comp *composition) {
+	if c == nil || comp == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.composing, comp)
+}
+
+// read remembers the resource as a part of the document which has been read.
+func (comp *composition) read(key cache.ResourceKey) {
+	if comp == nil {
+		return
+	}
+
+	comp.cache.Lock()
+	defer comp.cache.Unlock()
+
+	comp.reads[key] = comp.cache.seq
+}
+
+// outdated returns true if any of the parts changed after it was read.
+// Changes before that such as caching the part by the subrequest don't matter.
+func (comp *composition) outdated() bool {
+	for key, read := range comp.reads {
+		if changed, ok := comp.changes[key]; ok && changed > read {
+			return true
+		}
+	}
+	return false
+}
+
+// add caches a copy of the composed document if possible and remembers its parts.
+// The document isn't cached if any of its parts changed after it was read.
+func (c *CompositeCache) add(req *http.Request, rep *cache.Representation, comp *composition) {
+	if c == nil || comp == nil || !cache.Cacheable(req, rep) {
+		return
+	}
+
+	key := cache.NewResourceKey(req)
+
+	// the lock is held until the composed document is stored so that neither Changed nor sweep misses it.
+	c.Lock()
+	defer c.Unlock()
+
+	if comp.outdated() {
+		return
+	}
+
+	parts := make([]cache.ResourceKey, 0, len(comp.reads))
+	for p := range comp.reads {
+		parts = append(parts, p)
+	}
+
+	if c.dependents == nil {
+		c.dependents = make(map[cache.ResourceKey]map[cache.ResourceKey]struct{})
+		c.parts = make(map[cache.ResourceKey][]cache.ResourceKey)
+	}
+	c.forget(key)
+	for _, p := range parts {
+		if c.dependents[p] == nil {
+			c.dependents[p] = make(map[cache.ResourceKey]struct{})
+		}
+		c.dependents[p][key] = struct{}{}
+	}
+	c.parts[key] = parts
+
+	// the composed document might be modified afterwards for this request only.
+	// diagnostic header fields are for this request only as well.
+	h := make(http.Header, len(rep.HeaderMap))
+	for k, vs := range rep.HeaderMap {
+		h[k] = append([]string(nil), vs...)
+	}
+	cache.DelDiagnostics(h)
+
+	c.Set(req, &cache.Representation{
+		StatusCode:   rep.StatusCode,
+		HeaderMap:    h,
+		Body:         rep.Body,
+		RequestTime:  rep.RequestTime,
+		ResponseTime: rep.ResponseTime,
+	})
+
+	// forget the composed documents evicted to make room for this one.
+	if n := c.evictionCount(); n != c.evictions {
+		c.evictions = n
+		c.sweep()
+	}
+}
+
+// forget removes the composed document from the dependents of its parts.
+func (c *CompositeCache) forget(key cache.ResourceKey) {
+	for _, p := range c.parts[key] {
+		delete(c.dependents[p], key)
+		if len(c.dependents[p]) == 0 {
+			delete(c.dependents, p)
+		}
+	}
+	delete(c.parts, key)
+}
+
+// sweep forgets the composed documents which are no longer in the storage.
+func (c *CompositeCache) sweep() {
+	cached := make(map[cache.ResourceKey]struct{}, len(c.parts))
+	c.Each(func(res *cache.Resource, _ *cache.Representation, _ uint64) {
+		cached[res.ResourceKey] = struct{}{}
+	})
+
+	for key := range c.parts {
+		if _, ok := cached[key]; !ok {
+			c.forget(key)
+		}
+	}
+}
+
+func (c *CompositeCache) evictionCount() uint64 {
+	var n uint64
+	for _, u := range c.Usage() {
+		n += u.Evictions
+	}
+	return n
+}
+
+// compositeRequest returns a request identifying the composed document by its URL and the normalized specs.
+// It returns nil if the request doesn't compose documents.
+func compositeRequest(r *http.Request, spec, fields, includes specifier, vars map[string]url.Values) *http.Request {
+	if r.Method != http.MethodGet {
+		return nil
+	}
+
+	if len(spec) == 0 && len(fields) == 0 && len(includes) == 0 {
+		return nil
+	}
+
+	q := r.URL.Query()
+	if len(spec) > 0 {
+		q[with] = spec.paths()
+	}
+	if len(fields) > 0 {
+		q[fieldsParam] = fields.paths()
+	}
+	if len(includes) > 0 {
+		q[include] = includes.paths()
+	}
+	for edge, vs := range vars {
+		for k, v := range vs {
+			q[edge+"."+k] = v
+		}
+	}
+
+	u := *r.URL
+	u.RawQuery = q.Encode()
+
+	return &http.Request{
+		Method: r.Method,
+		URL:    &u,
+		Header: r.Header,
+	}
+}
+
+var edgeEscaper = strings.NewReplacer(`\`, `\\`, `.`, `\.`)
+
+// paths returns the sorted paths to the leaves of the specifier in the same form as `with` query parameter.
+func (s specifier) paths() []string {
+	var ps []string
+	for edge, next := range s {
+		e := edgeEscaper.Replace(edge)
+		if len(next) == 0 {
+			ps = append(ps, e)
+			continue
+		}
+		for _, p := range next.paths() {
+			ps = append(ps, e+"."+p)
+		}
+	}
+	sort.Strings(ps)
+	return ps
+}
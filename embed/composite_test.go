@@ -0,0 +1,212 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_ServeHTTP_composites(t *testing.T) {
+	th := &countingHandler{
+		testHandler: testHandler{
+			T: t,
+			Resources: map[string]*testResource{
+				"/a": {
+					header: http.Header{"Content-Type": []string{"application/json"}, "Cache-Control": []string{"max-age=60"}},
+					body:   `{"_links":{"foo":{"href":"/b"}}}`,
+				},
+				"/b": {
+					header: http.Header{"Content-Type": []string{"application/json"}, "Cache-Control": []string{"max-age=30"}},
+					body:   `{}`,
+				},
+			},
+		},
+		calls: map[string]int{},
+	}
+	e := Handler{
+		Next:       th,
+		Composites: &CompositeCache{Storage: &cache.Store{}, Cache: &cache.Handler{}},
+	}
+
+	serve := func(query string) *cache.Representation {
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     "/a",
+				RawQuery: query,
+			},
+		})
+		return &rep
+	}
+
+	body := `{"_links":{"foo":{"href":"/b"}},"_embedded":{"foo":{}}}`
+
+	for i, query := range []string{"with=foo", "with=foo", "with=foo&with=foo"} {
+		rep := serve(query)
+		if body != string(rep.Body) {
+			t.Errorf("(%d) expected: %s, got: %s", i, body, string(rep.Body))
+		}
+		if cc := rep.HeaderMap.Get("Cache-Control"); cc != "max-age=30" {
+			t.Errorf("(%d) expected max-age=30, got %s", i, cc)
+		}
+	}
+
+	// the composed document is cached regardless of duplicated edges.
+	if th.calls["/a"] != 1 || th.calls["/b"] != 1 {
+		t.Errorf("expected 1 call for each, got %v", th.calls)
+	}
+
+	// the composed document is purged when one of its parts changes.
+	e.Composites.Changed(cache.ResourceKey{Path: "/b"})
+	serve("with=foo")
+	if th.calls["/a"] != 2 || th.calls["/b"] != 2 {
+		t.Errorf("expected 2 calls for each, got %v", th.calls)
+	}
+
+	// the composed document is outdated when the origin changes (e.g. POST).
+	e.Composites.Cache.OriginChangedAt = time.Now()
+	serve("with=foo")
+	serve("with=foo")
+	if th.calls["/a"] != 3 || th.calls["/b"] != 3 {
+		t.Errorf("expected 3 calls for each, got %v", th.calls)
+	}
+}
+
+func TestHandler_ServeHTTP_compositesDiagnostics(t *testing.T) {
+	e := Handler{
+		Next: &testHandler{
+			T: t,
+			Resources: map[string]*testResource{
+				"/a": {
+					header: http.Header{
+						"Content-Type":  []string{"application/json"},
+						"Cache-Control": []string{"max-age=60"},
+						"X-Cache":       []string{"MISS"},
+						"Server-Timing": []string{`cache;desc="lookup";dur=0.010`},
+					},
+					body: `{"_links":{"foo":{"href":"/b"}}}`,
+				},
+				"/b": {
+					header: http.Header{"Content-Type": []string{"application/json"}, "Cache-Control": []string{"max-age=30"}},
+					body:   `{}`,
+				},
+			},
+		},
+		Composites: &CompositeCache{Storage: &cache.Store{}},
+	}
+
+	for i := 0; i < 2; i++ {
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     "/a",
+				RawQuery: "with=foo",
+			},
+		})
+
+		// the diagnostic header fields of the first request aren't served from the cache.
+		if i == 0 {
+			continue
+		}
+		for _, f := range []string{"X-Cache", "Server-Timing"} {
+			if v, ok := rep.HeaderMap[f]; ok {
+				t.Errorf("expected no %s, got %v", f, v)
+			}
+		}
+	}
+}
+
+func TestCompositeCache_add(t *testing.T) {
+	c := CompositeCache{Storage: &cache.Store{Max: 10, Sample: 10}}
+
+	req := func(path string) *http.Request {
+		return &http.Request{
+			Method: http.MethodGet,
+			URL:    &url.URL{Path: path, RawQuery: "with=foo"},
+		}
+	}
+	rep := func() *cache.Representation {
+		return &cache.Representation{
+			StatusCode: http.StatusOK,
+			HeaderMap:  http.Header{"Cache-Control": []string{"max-age=60"}},
+			Body:       []byte(`{"a":"b"}`),
+		}
+	}
+
+	a, b := cache.ResourceKey{Path: "/a"}, cache.ResourceKey{Path: "/b"}
+
+	add := func(req *http.Request, parts ...cache.ResourceKey) {
+		comp := c.begin()
+		defer c.end(comp)
+		for _, p := range parts {
+			comp.read(p)
+		}
+		c.add(req, rep(), comp)
+	}
+
+	add(req("/x"), a, b)
+	if len(c.dependents) != 2 || len(c.parts) != 1 {
+		t.Errorf("expected 2 parts of 1 composed document, got %v and %v", c.dependents, c.parts)
+	}
+
+	// /x is evicted to make room for /y.
+	add(req("/y"), b)
+	if len(c.dependents) != 1 || len(c.dependents[b]) != 1 || len(c.parts) != 1 {
+		t.Errorf("expected 1 part of 1 composed document, got %v and %v", c.dependents, c.parts)
+	}
+
+	// /y is purged.
+	c.Changed(b)
+	if len(c.dependents) != 0 || len(c.parts) != 0 {
+		t.Errorf("expected no composed documents, got %v and %v", c.dependents, c.parts)
+	}
+}
+
+func TestCompositeCache_add_changed(t *testing.T) {
+	c := CompositeCache{Storage: &cache.Store{}}
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: "/x", RawQuery: "with=foo"},
+	}
+	rep := &cache.Representation{
+		StatusCode: http.StatusOK,
+		HeaderMap:  http.Header{"Cache-Control": []string{"max-age=60"}},
+		Body:       []byte(`{}`),
+	}
+	a, b := cache.ResourceKey{Path: "/a"}, cache.ResourceKey{Path: "/b"}
+
+	// changes before the parts are read such as caching them by the subrequests don't matter.
+	comp := c.begin()
+	c.Changed(a)
+	comp.read(a)
+	comp.read(b)
+	c.add(req, rep, comp)
+	c.end(comp)
+
+	if cached := c.Get(req); cached == nil {
+		t.Error("expected to be cached")
+	}
+
+	c.Changed(a)
+
+	// the document isn't cached if any of its parts changed after it was read.
+	comp = c.begin()
+	comp.read(a)
+	comp.read(b)
+	c.Changed(b)
+	c.add(req, rep, comp)
+	c.end(comp)
+
+	if cached := c.Get(req); cached != nil {
+		t.Errorf("expected not to be cached, got %#v", cached)
+	}
+	if len(c.composing) != 0 {
+		t.Errorf("expected no documents being composed, got %v", c.composing)
+	}
+}
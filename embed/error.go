@@ -3,6 +3,7 @@ package embed
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/ichiban/jesi/cache"
 )
@@ -67,3 +68,75 @@ func NewMalformedJSONError(err error, uri fmt.Stringer) *Error {
 		},
 	}
 }
+
+// NewDepthLimitError returns an error for a link deeper than allowed.
+func NewDepthLimitError(max int, uri fmt.Stringer) *Error {
+	return &Error{
+		Type:   "https://ichiban.github.io/jesi/problems/depth-limit-exceeded",
+		Title:  "Depth Limit Exceeded",
+		Detail: fmt.Sprintf("can't embed documents deeper than %d", max),
+		Links: map[string]interface{}{
+			about: uri.String(),
+		},
+	}
+}
+
+// NewSubrequestLimitError returns an error for a subrequest beyond the maximum number of subrequests.
+func NewSubrequestLimitError(max int, uri fmt.Stringer) *Error {
+	return &Error{
+		Type:   "https://ichiban.github.io/jesi/problems/subrequest-limit-exceeded",
+		Title:  "Subrequest Limit Exceeded",
+		Detail: fmt.Sprintf("can't make more than %d subrequests", max),
+		Links: map[string]interface{}{
+			about: uri.String(),
+		},
+	}
+}
+
+// NewSizeLimitError returns an error for a document which makes the resulting document too large.
+func NewSizeLimitError(max int, uri fmt.Stringer) *Error {
+	return &Error{
+		Type:   "https://ichiban.github.io/jesi/problems/size-limit-exceeded",
+		Title:  "Size Limit Exceeded",
+		Detail: fmt.Sprintf("can't embed documents larger than %d bytes in total", max),
+		Links: map[string]interface{}{
+			about: uri.String(),
+		},
+	}
+}
+
+// NewTimeoutError returns an error for a subrequest which didn't finish in time.
+func NewTimeoutError(err error, uri fmt.Stringer) *Error {
+	return &Error{
+		Type:   "https://ichiban.github.io/jesi/problems/timeout",
+		Title:  "Timeout",
+		Detail: err.Error(),
+		Links: map[string]interface{}{
+			about: uri.String(),
+		},
+	}
+}
+
+// NewDisallowedHostError returns an error for a link to a host which isn't allowed to embed.
+func NewDisallowedHostError(uri *url.URL) *Error {
+	return &Error{
+		Type:   "https://ichiban.github.io/jesi/problems/disallowed-host",
+		Title:  "Disallowed Host",
+		Detail: fmt.Sprintf("can't embed documents on %s", uri.Host),
+		Links: map[string]interface{}{
+			about: uri.String(),
+		},
+	}
+}
+
+// NewUnsupportedMediaTypeError returns an error for a sub request response which isn't JSON.
+func NewUnsupportedMediaTypeError(contentType string, uri fmt.Stringer) *Error {
+	return &Error{
+		Type:   "https://ichiban.github.io/jesi/problems/unsupported-media-type",
+		Title:  "Unsupported Media Type",
+		Detail: fmt.Sprintf("can't embed %q", contentType),
+		Links: map[string]interface{}{
+			about: uri.String(),
+		},
+	}
+}
@@ -0,0 +1,136 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+// expansion keeps track of subrequests within a single request so that each document is fetched only once.
+// It also enforces the limits on the request.
+type expansion struct {
+	sync.Mutex
+	Limits
+	subrequests map[string]*subrequest
+	count       int
+	size        int
+	slots       chan struct{}
+	vars        map[string]url.Values // variables for templated links by the rels of edges.
+	format      format                // format of the document and subdocuments of generic JSON.
+	composition *composition          // parts of the document if it's to be cached.
+}
+
+// newExpansion returns an expansion with the limits.
+func newExpansion(l Limits) *expansion {
+	x := expansion{
+		Limits:      l,
+		subrequests: make(map[string]*subrequest),
+	}
+	if l.MaxConcurrency > 0 {
+		x.slots = make(chan struct{}, l.MaxConcurrency)
+	}
+	return &x
+}
+
+// subrequest is a fetch of a document shared by the links resolving to the same URL.
+type subrequest struct {
+	done chan struct{}
+	rep  *cache.Representation
+	err  *Error
+}
+
+// fetch returns a representation for the request and true if it's fetched by another link.
+// It returns an error instead if the request has already made as many subrequests as allowed
+// or the representation isn't available in time.
+func (x *expansion) fetch(h http.Handler, key string, req *http.Request) (*cache.Representation, bool, *Error) {
+	x.Lock()
+	s, shared := x.subrequests[key]
+	if !shared {
+		s = &subrequest{
+			done: make(chan struct{}),
+		}
+		x.subrequests[key] = s
+		x.count++
+		if x.MaxSubrequests > 0 && x.count > x.MaxSubrequests {
+			s.err = NewSubrequestLimitError(x.MaxSubrequests, req.URL)
+			close(s.done)
+		} else {
+			go x.do(h, s, req)
+		}
+	}
+	x.Unlock()
+
+	ctx := req.Context()
+	if x.SubrequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, x.SubrequestTimeout)
+		defer cancel()
+	}
+
+	select {
+	case <-s.done:
+		return s.rep, shared, s.err
+	case <-ctx.Done():
+		return nil, shared, NewTimeoutError(ctx.Err(), req.URL)
+	}
+}
+
+// do makes the subrequest and notifies the links waiting for it.
+func (x *expansion) do(h http.Handler, s *subrequest, req *http.Request) {
+	defer close(s.done)
+
+	if x.slots != nil {
+		x.slots <- struct{}{}
+		defer func() { <-x.slots }()
+	}
+
+	if x.SubrequestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), x.SubrequestTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	key := cache.NewResourceKey(req)
+	s.rep = cache.NewRepresentation(h, req)
+	x.composition.read(key)
+}
+
+// grow adds the size of an embedded document and returns an error if the resulting document is too large.
+func (x *expansion) grow(n int, uri fmt.Stringer) *Error {
+	x.Lock()
+	defer x.Unlock()
+
+	if x.MaxSize > 0 && x.size+n > x.MaxSize {
+		return NewSizeLimitError(x.MaxSize, uri)
+	}
+
+	x.size += n
+	return nil
+}
+
+// add registers an already fetched representation so that links to it won't be fetched again.
+func (x *expansion) add(key string, rep *cache.Representation) {
+	x.Lock()
+	defer x.Unlock()
+
+	s := &subrequest{
+		done: make(chan struct{}),
+		rep:  rep,
+	}
+	close(s.done)
+	x.subrequests[key] = s
+}
+
+// cyclic returns true if the URL is one of the ancestors.
+func cyclic(ancestors []string, u string) bool {
+	for _, a := range ancestors {
+		if a == u {
+			return true
+		}
+	}
+	return false
+}
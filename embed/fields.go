@@ -0,0 +1,46 @@
+package embed
+
+const (
+	fieldsParam = "fields"
+	fieldsField = "Fields"
+)
+
+// project removes properties other than the fields from the HAL+JSON document and its embedded documents.
+// Fields with dot separated edges such as `roles.name` apply to the embedded documents.
+// Links and embedded documents are always kept.
+func project(data interface{}, fields specifier) {
+	switch d := data.(type) {
+	case []interface{}:
+		for _, v := range d {
+			project(v, fields)
+		}
+	case *object:
+		if properties(fields) {
+			for k := range d.members {
+				if k == links || k == embedded {
+					continue
+				}
+				if _, ok := fields[k]; !ok {
+					delete(d.members, k)
+				}
+			}
+		}
+
+		es, _ := members(d.members[embedded])
+		for rel, sub := range es {
+			if next := fields[rel]; len(next) > 0 {
+				project(sub, next)
+			}
+		}
+	}
+}
+
+// properties returns true if any of the fields is a property rather than an edge to embedded documents.
+func properties(fields specifier) bool {
+	for _, next := range fields {
+		if len(next) == 0 {
+			return true
+		}
+	}
+	return false
+}
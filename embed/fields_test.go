@@ -0,0 +1,83 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_ServeHTTP_fields(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"/movies/1": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}},
+				body:   `{"_links":{"roles":[{"href":"/roles/1"}]},"title":"Pulp Fiction","year":1994}`,
+			},
+			"/movies/1?fields=title": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}},
+				body:   `{"_links":{"roles":[{"href":"/roles/1"}]},"title":"Pulp Fiction"}`,
+			},
+			"/roles/1": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}},
+				body:   `{"_links":{"self":{"href":"/roles/1"}},"name":"Vincent Vega","note":"dance"}`,
+			},
+		},
+	}
+
+	testCases := []struct {
+		query  string
+		header http.Header
+		body   string
+	}{
+		{ // without fields, it returns everything.
+			query: "with=roles",
+			body:  `{"_links":{"roles":[{"href":"/roles/1"}]},"title":"Pulp Fiction","year":1994,"_embedded":{"roles":[{"_links":{"self":{"href":"/roles/1"}},"name":"Vincent Vega","note":"dance"}]}}`,
+		},
+		{ // fields select properties of the document and embedded documents.
+			query: "with=roles&fields=title&fields=roles.name",
+			body:  `{"_links":{"roles":[{"href":"/roles/1"}]},"title":"Pulp Fiction","_embedded":{"roles":[{"_links":{"self":{"href":"/roles/1"}},"name":"Vincent Vega"}]}}`,
+		},
+		{ // fields only for embedded documents keep the properties of the document.
+			query:  "with=roles",
+			header: http.Header{"Fields": []string{`"roles.name"`}},
+			body:   `{"_links":{"roles":[{"href":"/roles/1"}]},"title":"Pulp Fiction","year":1994,"_embedded":{"roles":[{"_links":{"self":{"href":"/roles/1"}},"name":"Vincent Vega"}]}}`,
+		},
+	}
+
+	for i, tc := range testCases {
+		e := Handler{Next: th, Fields: true}
+
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     "/movies/1",
+				RawQuery: tc.query,
+			},
+			Header: tc.header,
+		})
+
+		if tc.body != string(rep.Body) {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.body, string(rep.Body))
+		}
+	}
+	// without Fields, fields query parameter is sent to upstream.
+	e := Handler{Next: th}
+
+	var rep cache.Representation
+	e.ServeHTTP(&rep, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path:     "/movies/1",
+			RawQuery: "with=roles&fields=title",
+		},
+	})
+
+	body := `{"_links":{"roles":[{"href":"/roles/1"}]},"title":"Pulp Fiction","_embedded":{"roles":[{"_links":{"self":{"href":"/roles/1"}},"name":"Vincent Vega","note":"dance"}]}}`
+	if body != string(rep.Body) {
+		t.Errorf("expected: %s, got: %s", body, string(rep.Body))
+	}
+}
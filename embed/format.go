@@ -0,0 +1,50 @@
+package embed
+
+import (
+	"net/http"
+	"regexp"
+)
+
+var (
+	halPattern     = regexp.MustCompile(`\Aapplication/hal\+json`)
+	jsonAPIPattern = regexp.MustCompile(`\Aapplication/vnd\.api\+json`)
+	sirenPattern   = regexp.MustCompile(`\Aapplication/vnd\.siren\+json`)
+	jsonLDPattern  = regexp.MustCompile(`\Aapplication/ld\+json`)
+)
+
+// format embeds linked documents into documents of a hypermedia format.
+type format interface {
+	// embed fetches documents linked by the edges of the spec and embeds them into the document.
+	embed(h *Handler, base *http.Request, x *expansion, doc *document, spec specifier)
+}
+
+var (
+	_ format = hal{}
+	_ format = jsonAPI{}
+	_ format = siren{}
+	_ format = jsonLD{}
+)
+
+// format returns the format for the content type. Documents in generic JSON are considered HAL+JSON.
+func (h *Handler) format(contentType string) format {
+	if f, ok := h.formatOf(contentType); ok {
+		return f
+	}
+	return hal{}
+}
+
+// formatOf returns the format specific to the content type.
+func (h *Handler) formatOf(contentType string) (format, bool) {
+	switch {
+	case halPattern.MatchString(contentType):
+		return hal{}, true
+	case h.JSONAPI && jsonAPIPattern.MatchString(contentType):
+		return jsonAPI{}, true
+	case sirenPattern.MatchString(contentType):
+		return siren{}, true
+	case jsonLDPattern.MatchString(contentType):
+		return jsonLD{}, true
+	default:
+		return nil, false
+	}
+}
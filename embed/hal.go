@@ -0,0 +1,317 @@
+package embed
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	curies   = "curies"
+	linkName = "name"
+	nextRel  = "next"
+)
+
+// hal embeds documents linked by `_links` into `_embedded` of HAL+JSON documents.
+// Edges match rels in either compact or expanded form of CURIEs and select links of the same rel by name with `rel[name]`
+// or by other properties with `rel[property=value]`.
+// Edges with `*` suffix such as `roles*` also embed documents linked by the following pages of the collection.
+type hal struct{}
+
+func (hal) embed(h *Handler, base *http.Request, x *expansion, doc *document, spec specifier) {
+	es, ts := hal{}.prepare(h, base, x, doc, spec)
+	if len(ts) == 0 {
+		return
+	}
+
+	ch := make(chan *document, len(ts))
+	defer close(ch)
+
+	for _, t := range ts {
+		go h.fetch(base, x, doc.path, t.rel, t.pos, t.link, t.vars, t.next, ch)
+	}
+
+	for range ts {
+		sub := <-ch
+		if sub.pos == nil {
+			es[sub.edge] = sub.data
+		} else {
+			es[sub.edge].([]interface{})[*sub.pos] = sub.data
+		}
+		doc.merge(sub)
+	}
+}
+
+// linkTarget is a link to fetch and embed at the position of the rel in `_embedded`.
+type linkTarget struct {
+	rel  string
+	pos  *int
+	link map[string]interface{}
+	vars url.Values // variables for the templated link.
+	next specifier
+}
+
+// prepare returns `_embedded` of the document and the links to embed into it.
+// The embedded documents of the targets are nil until they're fetched.
+func (hal) prepare(h *Handler, base *http.Request, x *expansion, doc *document, spec specifier) (map[string]interface{}, []linkTarget) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+
+	parent, ok := members(doc.data)
+	if !ok {
+		return nil, nil
+	}
+	ls, _ := members(parent[links])
+	es, ok := members(parent[embedded])
+	if !ok {
+		o := newObject()
+		parent[embedded] = o
+		es = o.members
+	}
+
+	cs := newCURIEs(ls)
+
+	// edges for the same rel are embedded together.
+	selectors := map[string][]selector{}
+	paginated := map[string][]interface{}{}
+	vars := map[string]url.Values{}
+	for edge, next := range spec {
+		key := varsKey(edge)
+		edge, all := parsePaginated(edge)
+		rel, filter := parseEdge(edge)
+		rel, ok := cs.find(ls, rel)
+		if !ok {
+			continue
+		}
+		if all {
+			paginated[rel] = appendLinks(nil, ls[rel])
+		}
+		selectors[rel] = append(selectors[rel], selector{filter: filter, next: next})
+		if vs := x.vars[key]; len(vs) > 0 {
+			vars[rel] = vs
+		}
+	}
+
+	// links of the same rels in the following pages are concatenated.
+	if len(paginated) > 0 {
+		pages, e := h.pages(base, x, doc, ls)
+		for _, page := range pages {
+			doc.merge(page)
+			p, _ := members(page.data)
+			pls, _ := members(p[links])
+			pcs := newCURIEs(pls)
+			for rel, l := range paginated {
+				if r, ok := pcs.find(pls, cs.expand(rel)); ok {
+					paginated[rel] = appendLinks(l, pls[r])
+				}
+			}
+		}
+		if e != nil {
+			es[nextRel] = e.data
+			doc.merge(e)
+		}
+	}
+
+	var ts []linkTarget
+	for rel, ss := range selectors {
+		var l interface{} = ls[rel]
+		if p, ok := paginated[rel]; ok {
+			l = p
+		}
+		switch l := l.(type) {
+		case *object:
+			next, ok := selectLink(ss, l.members)
+			if !ok || skip(vars[rel], l.members) {
+				continue
+			}
+			es[rel] = nil
+			ts = append(ts, linkTarget{rel: rel, link: l.members, vars: vars[rel], next: next})
+		case []interface{}:
+			var n int
+			for _, l := range l {
+				l, ok := members(l)
+				if !ok {
+					continue
+				}
+				next, ok := selectLink(ss, l)
+				if !ok || skip(vars[rel], l) {
+					continue
+				}
+				i := n
+				n++
+				ts = append(ts, linkTarget{rel: rel, pos: &i, link: l, vars: vars[rel], next: next})
+			}
+			es[rel] = make([]interface{}, n)
+		}
+	}
+
+	return es, ts
+}
+
+// pages fetches the following pages of the collection by `next` links up to the page limit.
+// If it fails to fetch a page, it returns the pages so far and an error document.
+func (h *Handler) pages(base *http.Request, x *expansion, doc *document, ls map[string]interface{}) ([]*document, *document) {
+	visited := map[string]struct{}{
+		doc.path[len(doc.path)-1]: {},
+	}
+
+	var pages []*document
+	for x.MaxPages == 0 || len(pages) < x.MaxPages {
+		l, ok := members(ls[nextRel])
+		if !ok {
+			break
+		}
+
+		i := len(pages)
+		page, _, ok := h.get(base, x, doc.path, nextRel, &i, l, nil)
+		if !ok {
+			return pages, page
+		}
+
+		// the last page links back to one of the pages.
+		key := page.path[len(page.path)-1]
+		if _, ok := visited[key]; ok {
+			break
+		}
+		visited[key] = struct{}{}
+
+		pages = append(pages, page)
+		p, _ := members(page.data)
+		ls, _ = members(p[links])
+	}
+	return pages, nil
+}
+
+// parsePaginated returns the edge without `*` suffix and true if it has the suffix.
+func parsePaginated(edge string) (string, bool) {
+	if !strings.HasSuffix(edge, "*") {
+		return edge, false
+	}
+	return edge[:len(edge)-1], true
+}
+
+// appendLinks appends a link or links of a rel to the links.
+func appendLinks(ls []interface{}, l interface{}) []interface{} {
+	switch l := l.(type) {
+	case *object:
+		return append(ls, l)
+	case []interface{}:
+		return append(ls, l...)
+	default:
+		return ls
+	}
+}
+
+// selector selects links of a rel by their properties. An empty filter selects all of them.
+type selector struct {
+	filter map[string]string
+	next   specifier
+}
+
+// parseEdge returns the rel and the filter of an edge such as `roles[lead]` or `roles[name=lead,type=application/hal+json]`.
+// A condition without a property such as `lead` is a shorthand for `name=lead`.
+func parseEdge(edge string) (string, map[string]string) {
+	if !strings.HasSuffix(edge, "]") {
+		return edge, nil
+	}
+
+	i := strings.LastIndex(edge, "[")
+	if i < 0 {
+		return edge, nil
+	}
+
+	filter := map[string]string{}
+	for _, c := range strings.Split(edge[i+1:len(edge)-1], ",") {
+		j := strings.IndexByte(c, '=')
+		if j < 0 {
+			filter[linkName] = c
+			continue
+		}
+		filter[c[:j]] = c[j+1:]
+	}
+
+	return edge[:i], filter
+}
+
+// selectLink returns the spec to follow the link and true if any of the selectors selects the link.
+func selectLink(ss []selector, l map[string]interface{}) (specifier, bool) {
+	next := specifier{}
+	ok := false
+	for _, s := range ss {
+		if !matchLink(s.filter, l) {
+			continue
+		}
+		next.merge(s.next)
+		ok = true
+	}
+	return next, ok
+}
+
+// matchLink returns true if the link has all the properties of the filter.
+func matchLink(filter map[string]string, l map[string]interface{}) bool {
+	for k, v := range filter {
+		p, ok := l[k]
+		if !ok || fmt.Sprint(p) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// curieMap maps CURIE prefixes to the templated hrefs such as `http://example.com/rels/{rel}`.
+type curieMap map[string]string
+
+func newCURIEs(ls map[string]interface{}) curieMap {
+	cs := curieMap{}
+	vs, _ := ls[curies].([]interface{})
+	for _, v := range vs {
+		v, ok := members(v)
+		if !ok {
+			continue
+		}
+		n, _ := v[linkName].(string)
+		h, _ := v[href].(string)
+		if n == "" || h == "" {
+			continue
+		}
+		cs[n] = h
+	}
+	return cs
+}
+
+// expand returns the expanded form of the rel if it's a CURIE.
+func (cs curieMap) expand(rel string) string {
+	i := strings.Index(rel, ":")
+	if i < 0 {
+		return rel
+	}
+
+	t, ok := cs[rel[:i]]
+	if !ok {
+		return rel
+	}
+
+	return strings.Replace(t, "{rel}", rel[i+1:], 1)
+}
+
+// find returns the rel in the links which is the same as the given rel in either compact or expanded form.
+func (cs curieMap) find(ls map[string]interface{}, rel string) (string, bool) {
+	if rel == curies {
+		return "", false
+	}
+
+	if _, ok := ls[rel]; ok {
+		return rel, true
+	}
+
+	e := cs.expand(rel)
+	for r := range ls {
+		if r != curies && cs.expand(r) == e {
+			return r, true
+		}
+	}
+
+	return "", false
+}
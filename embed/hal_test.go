@@ -0,0 +1,207 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_ServeHTTP_hal(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"/movies/1": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}},
+				body:   `{"_links":{"curies":[{"name":"ex","href":"http://example.com/rels/{rel}","templated":true}],"ex:director":{"href":"/people/1"},"http://example.com/rels/actor":[{"href":"/people/2","name":"travolta","profile":"http://example.com/profiles/lead"},{"href":"/people/3","name":"thurman","profile":"http://example.com/profiles/lead"}],"example.com/genre":{"href":"/genres/1"}}}`,
+			},
+			"/people/1": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}},
+				body:   `{"name":"Quentin Tarantino"}`,
+			},
+			"/people/2": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}},
+				body:   `{"name":"John Travolta"}`,
+			},
+			"/people/3": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}},
+				body:   `{"name":"Uma Thurman"}`,
+			},
+			"/genres/1": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}},
+				body:   `{"name":"Crime"}`,
+			},
+		},
+	}
+
+	links := `"_links":{"curies":[{"name":"ex","href":"http://example.com/rels/{rel}","templated":true}],"ex:director":{"href":"/people/1"},"http://example.com/rels/actor":[{"href":"/people/2","name":"travolta","profile":"http://example.com/profiles/lead"},{"href":"/people/3","name":"thurman","profile":"http://example.com/profiles/lead"}],"example.com/genre":{"href":"/genres/1"}}`
+
+	testCases := []struct {
+		query string
+		body  string
+	}{
+		{ // CURIEs match in the compact form.
+			query: "with=ex:director&with=ex:actor",
+			body:  `{` + links + `,"_embedded":{"ex:director":{"name":"Quentin Tarantino"},"http://example.com/rels/actor":[{"name":"John Travolta"},{"name":"Uma Thurman"}]}}`,
+		},
+		{ // CURIEs match in the expanded form.
+			query: `with=http://example\.com/rels/director`,
+			body:  `{` + links + `,"_embedded":{"ex:director":{"name":"Quentin Tarantino"}}}`,
+		},
+		{ // dots in rels are escaped by backslashes.
+			query: `with=example\.com/genre`,
+			body:  `{` + links + `,"_embedded":{"example.com/genre":{"name":"Crime"}}}`,
+		},
+		{ // links of the same rel are selected by name.
+			query: "with=ex:actor[thurman]",
+			body:  `{` + links + `,"_embedded":{"http://example.com/rels/actor":[{"name":"Uma Thurman"}]}}`,
+		},
+		{ // links of the same rel are selected by properties.
+			query: "with=ex:actor[name=travolta]",
+			body:  `{` + links + `,"_embedded":{"http://example.com/rels/actor":[{"name":"John Travolta"}]}}`,
+		},
+		{ // all the properties have to match.
+			query: "with=ex:actor[profile=http://example.com/profiles/lead,name=thurman]",
+			body:  `{` + links + `,"_embedded":{"http://example.com/rels/actor":[{"name":"Uma Thurman"}]}}`,
+		},
+		{ // links without the property don't match.
+			query: "with=ex:director[profile=http://example.com/profiles/lead]",
+			body:  `{` + links + `,"_embedded":{}}`,
+		},
+	}
+
+	for i, tc := range testCases {
+		e := Handler{Next: th}
+
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     "/movies/1",
+				RawQuery: tc.query,
+			},
+		})
+
+		if tc.body != string(rep.Body) {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.body, string(rep.Body))
+		}
+	}
+}
+
+func TestSplitEdges(t *testing.T) {
+	testCases := []struct {
+		with  string
+		edges []string
+	}{
+		{with: "foo.bar", edges: []string{"foo", "bar"}},
+		{with: `example\.com/foo.bar`, edges: []string{"example.com/foo", "bar"}},
+		{with: `foo\\.bar`, edges: []string{`foo\`, "bar"}},
+		{with: "foo[profile=http://example.com/bar].baz", edges: []string{"foo[profile=http://example.com/bar]", "baz"}},
+	}
+
+	for _, tc := range testCases {
+		edges := splitEdges(tc.with)
+		if len(tc.edges) != len(edges) {
+			t.Errorf("(%s) expected %v, got %v", tc.with, tc.edges, edges)
+			continue
+		}
+		for i := range edges {
+			if tc.edges[i] != edges[i] {
+				t.Errorf("(%s) expected %v, got %v", tc.with, tc.edges, edges)
+			}
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_paginated(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"/roles?page=1": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=60"}},
+				body:   `{"_links":{"next":{"href":"/roles?page=2"},"roles":[{"href":"/roles/1"},{"href":"/roles/2"}]}}`,
+			},
+			"/roles?page=2": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=30"}},
+				body:   `{"_links":{"next":{"href":"/roles?page=3"},"roles":[{"href":"/roles/3"}]}}`,
+			},
+			"/roles?page=3": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=60"}},
+				body:   `{"_links":{"next":{"href":"/roles?page=1"},"roles":{"href":"/roles/4"}}}`,
+			},
+			"/roles?page=9": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=60"}},
+				body:   `{"_links":{"next":{"href":"/roles?page=10"},"roles":{"href":"/roles/1"}}}`,
+			},
+			"/roles/1": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=60"}},
+				body:   `{"name":"Vincent Vega"}`,
+			},
+			"/roles/2": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=60"}},
+				body:   `{"name":"Mia Wallace"}`,
+			},
+			"/roles/3": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=60"}},
+				body:   `{"name":"Jules Winnfield"}`,
+			},
+			"/roles/4": {
+				header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=60"}},
+				body:   `{"name":"Butch Coolidge"}`,
+			},
+		},
+	}
+
+	links := `"_links":{"next":{"href":"/roles?page=2"},"roles":[{"href":"/roles/1"},{"href":"/roles/2"}]}`
+
+	testCases := []struct {
+		limits       Limits
+		query        string
+		cacheControl string
+		body         string
+	}{
+		{ // without `*`, only the first page is embedded.
+			query:        "page=1&with=roles",
+			cacheControl: "max-age=60",
+			body:         `{` + links + `,"_embedded":{"roles":[{"name":"Vincent Vega"},{"name":"Mia Wallace"}]}}`,
+		},
+		{ // with `*`, the pages are followed until the last page links back to the first one.
+			query:        "page=1&with=roles*",
+			cacheControl: "max-age=30",
+			body:         `{` + links + `,"_embedded":{"roles":[{"name":"Vincent Vega"},{"name":"Mia Wallace"},{"name":"Jules Winnfield"},{"name":"Butch Coolidge"}]}}`,
+		},
+		{ // the pages are followed up to the limit.
+			limits:       Limits{MaxPages: 1},
+			query:        "page=1&with=roles*",
+			cacheControl: "max-age=30",
+			body:         `{` + links + `,"_embedded":{"roles":[{"name":"Vincent Vega"},{"name":"Mia Wallace"},{"name":"Jules Winnfield"}]}}`,
+		},
+		{ // a page which can't be fetched is embedded as an error.
+			query:        "page=9&with=roles*",
+			cacheControl: "no-store,max-age=60",
+			body:         `{"_links":{"next":{"href":"/roles?page=10"},"roles":{"href":"/roles/1"}},"_embedded":{"next":{"type":"https://ichiban.github.io/jesi/problems/response-error","title":"Response Error","status":404,"detail":"Not Found","_links":{"about":"/roles?page=10"}},"roles":[{"name":"Vincent Vega"}]}}`,
+		},
+	}
+
+	for i, tc := range testCases {
+		e := Handler{Next: th, Limits: tc.limits}
+
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     "/roles",
+				RawQuery: tc.query,
+			},
+		})
+
+		if cc := rep.HeaderMap.Get(cacheControlField); tc.cacheControl != cc {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.cacheControl, cc)
+		}
+
+		if tc.body != string(rep.Body) {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.body, string(rep.Body))
+		}
+	}
+}
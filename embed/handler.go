@@ -1,14 +1,17 @@
 package embed
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5" // #nosec
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ichiban/jesi/cache"
 	"github.com/ichiban/jesi/transaction"
@@ -35,9 +38,38 @@ const (
 
 var jsonPattern = regexp.MustCompile(`\Aapplication/(?:.+\+)?json`)
 
+var errNotObject = errors.New("not a JSON object")
+
+// credentialFields are request header fields which aren't sent along with subrequests to other hosts.
+var credentialFields = map[string]struct{}{
+	"Authorization":       {},
+	"Cookie":              {},
+	"Proxy-Authorization": {},
+}
+
 // Handler is an embedding handler.
 type Handler struct {
-	Next http.Handler
+	Next        http.Handler
+	Diagnostics *cache.Diagnostics
+	Limits      Limits
+
+	// JSONAPI enables embedding for JSON:API documents with `include` query parameter.
+	JSONAPI bool
+
+	// Fields enables field selection of HAL+JSON documents with `fields` query parameter.
+	// Otherwise, the parameter is sent to upstream as it is for origins which handle it by themselves.
+	Fields bool
+
+	// Composites caches composed documents if it's not nil.
+	Composites *CompositeCache
+
+	// Stream writes HAL+JSON documents before their embedded documents are fetched and each of the embedded documents
+	// as soon as it's fetched. Streamed documents aren't cached as composed documents.
+	Stream bool
+
+	// Hosts allows embedding documents on other hosts than the request's. Subrequests to them are sent to the handlers.
+	// Subrequests to hosts with nil handlers are sent to Next.
+	Hosts map[string]http.Handler
 }
 
 var _ http.Handler = (*Handler)(nil)
@@ -45,10 +77,55 @@ var _ http.Handler = (*Handler)(nil)
 // ServeHTTP fetches a response from the underlying handler and if it contains links matching the embedding spec,
 // also fetches linked documents and embeds them.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	spec := stripSpec(r)
+	// the token is sent neither to upstream nor along with subrequests.
+	r = h.Diagnostics.Strip(r)
+
+	spec := stripSpec(r, with, withField)
+	var fields specifier
+	if h.Fields {
+		fields = stripSpec(r, fieldsParam, fieldsField)
+	}
+	vars := stripVars(r, spec)
+	var includes specifier
+	if h.JSONAPI {
+		includes = stripIncludes(r)
+	}
+
+	key := compositeRequest(r, spec, fields, includes, vars)
+	if key != nil {
+		if cached := h.Composites.lookup(key); cached != nil {
+			log.WithFields(log.Fields{
+				"id": transaction.ID(r),
+			}).Debug("Will serve a cached composed document")
+
+			if _, err := cached.WriteTo(w); err != nil {
+				log.WithFields(log.Fields{
+					"id":    transaction.ID(r),
+					"error": err,
+				}).Error("Couldn't write a response")
+			}
+			return
+		}
+	}
+
+	// Track changes of the parts from before fetching them so that outdated documents aren't cached.
+	var comp *composition
+	if key != nil {
+		comp = h.Composites.begin()
+		defer h.Composites.end(comp)
+	}
+
+	// Keep the resource key and URL since `balance.Handler` will modify the request.
+	top := cache.NewResourceKey(r)
+	origURL := *r.URL
 
 	rep := cache.NewRepresentation(h.Next, r)
+	comp.read(top)
+	streamed := false
 	defer func() {
+		if streamed {
+			return
+		}
 		rep.HeaderMap.Set(contentLengthField, strconv.Itoa(len(rep.Body)))
 		if _, err := rep.WriteTo(w); err != nil {
 			log.WithFields(log.Fields{
@@ -62,22 +139,63 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var data map[string]interface{}
-	if err := json.Unmarshal(rep.Body, &data); err != nil {
+	v, err := decode(rep.Body)
+	if err != nil {
+		return
+	}
+	data, ok := v.(*object)
+	if !ok {
 		return
 	}
 
 	doc := &document{
 		CacheControl: NewCacheControl(rep),
+		tags:         cache.Tags(rep.HeaderMap),
+		path:         []string{origURL.String()},
 		data:         data,
 	}
-	h.embed(r, doc, spec)
+	x := newExpansion(h.Limits)
+	x.size = len(rep.Body)
+	x.composition = comp
+	x.vars = vars
+	x.format = h.format(rep.HeaderMap.Get(contentTypeField))
+	if _, ok := x.format.(jsonAPI); ok {
+		spec = includes
+	}
+	if r.Method == http.MethodGet {
+		x.add(origURL.String(), rep)
+	}
+
+	// unfinished edges are embedded as errors after the timeout so that clients get a partial result quickly.
+	ctx := r.Context()
+	if h.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Limits.Timeout)
+		defer cancel()
+	}
+	base := r.WithContext(ctx)
+	base.URL = &origURL
+
+	// documents are streamed only if there's something to embed. Otherwise, they're buffered and cacheable as usual.
+	if _, ok := x.format.(hal); ok && h.Stream {
+		if es, ts := (hal{}).prepare(h, base, x, doc, spec); len(ts) > 0 {
+			streamed = true
+			h.stream(w, base, x, rep, doc, es, ts, fields)
+			return
+		}
+	} else {
+		x.format.embed(h, base, x, doc, spec)
+	}
+
+	if _, ok := x.format.(hal); ok {
+		project(doc.data, fields)
+	}
 
 	delete(rep.HeaderMap, expiresField)
 	rep.HeaderMap[cacheControlField] = []string{doc.CacheControl.String()}
+	cache.SetTags(rep.HeaderMap, doc.tags)
 
-	var err error
-	rep.Body, err = json.Marshal(doc.data)
+	rep.Body, err = marshal(doc.data)
 	if err != nil {
 		return
 	}
@@ -91,6 +209,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		rep.HeaderMap.Set(warningField, `214 - "Transformation Applied"`)
 	}
 
+	if key != nil {
+		rep.HeaderMap.Set(contentLengthField, strconv.Itoa(len(rep.Body)))
+		h.Composites.add(key, rep, comp)
+	}
+
+	if h.Diagnostics.Enabled(r) {
+		cache.AddTimings(rep.HeaderMap, doc.timings...)
+	}
+
 	log.WithFields(log.Fields{
 		"id": transaction.ID(r),
 	}).Debug("Finished a request")
@@ -108,23 +235,69 @@ func (s specifier) add(edges []string) {
 	}
 }
 
-func stripSpec(req *http.Request) specifier {
+// each calls f for every edge in the specifier.
+func (s specifier) each(f func(edge string)) {
+	for edge, next := range s {
+		f(edge)
+		next.each(f)
+	}
+}
+
+// merge adds the edges of the other specifier.
+func (s specifier) merge(other specifier) {
+	for edge, next := range other {
+		if _, ok := s[edge]; !ok {
+			s[edge] = specifier{}
+		}
+		s[edge].merge(next)
+	}
+}
+
+// splitEdges splits dot separated edges. Dots and backslashes in edges are escaped by backslashes.
+// Dots in filters such as `roles[profile=http://example.com/lead]` don't split edges.
+func splitEdges(w string) []string {
+	var edges []string
+	var edge bytes.Buffer
+	filter := false
+	for i := 0; i < len(w); i++ {
+		switch c := w[i]; {
+		case c == '\\' && i+1 < len(w):
+			i++
+			edge.WriteByte(w[i])
+		case c == '.' && !filter:
+			edges = append(edges, edge.String())
+			edge.Reset()
+		default:
+			switch c {
+			case '[':
+				filter = true
+			case ']':
+				filter = false
+			}
+			edge.WriteByte(c)
+		}
+	}
+	return append(edges, edge.String())
+}
+
+// stripSpec removes the query parameter from the request and returns the spec of it and the header field.
+func stripSpec(req *http.Request, param, field string) specifier {
 	spec := specifier{}
-	for _, w := range req.URL.Query()[with] {
-		spec.add(strings.Split(w, "."))
+	for _, w := range req.URL.Query()[param] {
+		spec.add(splitEdges(w))
 	}
 
 	q := req.URL.Query()
-	q.Del(with)
+	q.Del(param)
 	req.URL.RawQuery = q.Encode()
 
-	for _, ws := range req.Header[withField] {
+	for _, ws := range req.Header[field] {
 		for _, w := range strings.Split(ws, ",") {
 			w = strings.TrimSpace(w)
 			if strings.HasPrefix(w, `"`) {
 				w = w[1 : len(w)-1]
 			}
-			spec.add(strings.Split(w, "."))
+			spec.add(splitEdges(w))
 		}
 	}
 
@@ -133,66 +306,99 @@ func stripSpec(req *http.Request) specifier {
 
 type document struct {
 	*CacheControl
-	edge string
-	pos  *int
-	data interface{}
+	tags    []string
+	timings []cache.Timing
+	edge    string
+	pos     *int
+	path    []string // URLs of the document and its ancestors.
+	data    interface{}
 }
 
-func (h *Handler) embed(base *http.Request, doc *document, spec specifier) {
-	if len(spec) == 0 {
-		return
+// merge merges the cache policy, cache tags and timings of the subdocument.
+func (doc *document) merge(sub *document) {
+	doc.CacheControl = doc.CacheControl.Merge(sub.CacheControl)
+	doc.tags = mergeTags(doc.tags, sub.tags)
+	doc.timings = append(doc.timings, sub.timings...)
+}
+
+// mergeTags returns the union of 2 sets of cache tags so that the resulting document is purged along with its parts.
+func mergeTags(a, b []string) []string {
+	for _, t := range b {
+		found := false
+		for _, u := range a {
+			if t == u {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, t)
+		}
 	}
+	return a
+}
 
-	parent := doc.data.(map[string]interface{})
-	ls := parent[links].(map[string]interface{})
-	es, ok := parent[embedded].(map[string]interface{})
+func (h *Handler) fetch(base *http.Request, x *expansion, path []string, edge string, pos *int, link map[string]interface{}, vars url.Values, next specifier, ch chan<- *document) {
+	doc, contentType, ok := h.get(base, x, path, edge, pos, link, vars)
 	if !ok {
-		m := make(map[string]interface{})
-		parent[embedded] = m
-		es = m
+		ch <- doc
+		return
 	}
 
-	ch := make(chan *document, len(spec))
-	defer close(ch)
+	// the document is already being embedded by one of its ancestors.
+	if key := doc.path[len(doc.path)-1]; cyclic(path, key) {
+		log.WithFields(log.Fields{
+			"id":   transaction.ID(base),
+			"href": key,
+		}).Debug("Won't embed further into a cyclic subdocument")
 
-	count := 0
-	for edge, next := range spec {
-		l, ok := ls[edge]
-		if !ok {
-			continue
-		}
+		next = nil
+	}
+	f, ok := h.formatOf(contentType)
+	if !ok {
+		f = x.format
+	}
+	f.embed(h, base, x, doc, next)
 
-		switch l := l.(type) {
-		case map[string]interface{}:
-			count++
-			go h.fetch(base, edge, nil, l[href].(string), next, ch)
-		case []interface{}:
-			es[edge] = make([]interface{}, len(l))
-			for i, l := range l {
-				i := i
-				l := l.(map[string]interface{})
-				count++
-				go h.fetch(base, edge, &i, l[href].(string), next, ch)
-			}
-		}
+	name := edgeName(edge, pos)
+	timings := []cache.Timing{doc.timings[0]}
+	for _, t := range doc.timings[1:] {
+		t.Desc = name + "." + t.Desc
+		timings = append(timings, t)
 	}
 
-	for i := 0; i < count; i++ {
-		sub := <-ch
-		if sub.pos == nil {
-			es[sub.edge] = sub.data
-		} else {
-			es[sub.edge].([]interface{})[*sub.pos] = sub.data
-		}
-		doc.CacheControl = doc.CacheControl.Merge(sub.CacheControl)
+	ch <- &document{
+		CacheControl: doc.CacheControl,
+		tags:         doc.tags,
+		timings:      timings,
+		edge:         edge,
+		pos:          pos,
+		data:         doc.data,
 	}
 }
 
-func (h *Handler) fetch(base *http.Request, edge string, pos *int, href string, next specifier, ch chan<- *document) {
+// get fetches the linked document and returns it along with its content type. Templated links are expanded with vars.
+// If it fails, it returns an error document and false instead.
+func (h *Handler) get(base *http.Request, x *expansion, path []string, edge string, pos *int, link map[string]interface{}, vars url.Values) (*document, string, bool) {
+	href, err := linkHref(vars, link)
+	if err != nil {
+		return errorDocument(edge, pos, NewMalformedURLError(err)), "", false
+	}
+
 	uri, err := url.Parse(href)
 	if err != nil {
-		ch <- errorDocument(edge, pos, NewMalformedURLError(err))
-		return
+		return errorDocument(edge, pos, NewMalformedURLError(err)), "", false
+	}
+
+	// relative hrefs are relative to the request.
+	u := base.URL.ResolveReference(uri)
+	upstream, ok := h.upstream(base.URL, u)
+	if !ok {
+		return errorDocument(edge, pos, NewDisallowedHostError(uri)), "", false
+	}
+
+	if x.MaxDepth > 0 && len(path) > x.MaxDepth {
+		return errorDocument(edge, pos, NewDepthLimitError(x.MaxDepth, uri)), "", false
 	}
 
 	log.WithFields(log.Fields{
@@ -200,53 +406,119 @@ func (h *Handler) fetch(base *http.Request, edge string, pos *int, href string,
 		"edge": edge,
 		"pos":  pos,
 		"href": uri,
-		"next": next,
 	}).Debug("Will fetch a subdocument")
 
-	req, err := http.NewRequest(http.MethodGet, uri.String(), nil)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
-		ch <- errorDocument(edge, pos, NewMalformedSubRequestError(err, uri))
-		return
+		return errorDocument(edge, pos, NewMalformedSubRequestError(err, uri)), "", false
 	}
 	req = req.WithContext(base.Context())
 	for k, vs := range base.Header {
+		// credentials for the request's host might be abused by other hosts.
+		if _, ok := credentialFields[k]; ok && u.Host != base.URL.Host {
+			continue
+		}
 		for _, v := range vs {
 			req.Header.Add(k, v)
 		}
 	}
 
-	rep := cache.NewRepresentation(h.Next, req)
+	key := u.String()
+
+	start := time.Now()
+	rep, shared, e := x.fetch(upstream, key, req)
+	if e != nil {
+		return errorDocument(edge, pos, e), "", false
+	}
+	d := rep.ResponseTime.Sub(rep.RequestTime)
+	if shared {
+		d = time.Since(start)
+		coalesced.Inc()
+	} else {
+		subrequestDuration.Observe(d.Seconds())
+		subrequests.Inc(strconv.Itoa(rep.StatusCode))
+	}
+
+	log.WithFields(log.Fields{
+		"child":  transaction.ID(req),
+		"parent": transaction.ID(base),
+	}).Debug("Finished a subrequest")
+
+	timing := cache.Timing{Name: "embed", Desc: edgeName(edge, pos), Duration: d}
+
 	if !rep.Successful() {
-		ch <- errorDocument(edge, pos, NewResponseError(rep, uri))
-		return
+		doc := errorDocument(edge, pos, NewResponseError(rep, uri))
+		doc.timings = []cache.Timing{timing}
+		return doc, "", false
 	}
 
-	var data map[string]interface{}
-	if err := json.Unmarshal(rep.Body, &data); err != nil {
-		ch <- errorDocument(edge, pos, NewMalformedJSONError(err, uri))
-		return
+	contentType := rep.HeaderMap.Get(contentTypeField)
+	if !jsonPattern.MatchString(contentType) {
+		doc := errorDocument(edge, pos, NewUnsupportedMediaTypeError(contentType, uri))
+		doc.timings = []cache.Timing{timing}
+		return doc, "", false
 	}
 
-	doc := &document{
-		CacheControl: NewCacheControl(rep),
-		data:         data,
+	if e := x.grow(len(rep.Body), uri); e != nil {
+		doc := errorDocument(edge, pos, e)
+		doc.timings = []cache.Timing{timing}
+		return doc, "", false
 	}
-	h.embed(base, doc, next)
 
-	ch <- &document{
-		CacheControl: doc.CacheControl,
+	v, err := decode(rep.Body)
+	if err != nil {
+		doc := errorDocument(edge, pos, NewMalformedJSONError(err, uri))
+		doc.timings = []cache.Timing{timing}
+		return doc, "", false
+	}
+	data, ok := v.(*object)
+	if !ok {
+		doc := errorDocument(edge, pos, NewMalformedJSONError(errNotObject, uri))
+		doc.timings = []cache.Timing{timing}
+		return doc, "", false
+	}
+
+	return &document{
+		CacheControl: NewCacheControl(rep),
+		tags:         cache.Tags(rep.HeaderMap),
+		timings:      []cache.Timing{timing},
 		edge:         edge,
 		pos:          pos,
-		data:         doc.data,
+		path:         append(append([]string{}, path...), key),
+		data:         data,
+	}, contentType, true
+}
+
+// upstream returns the handler for the URL and false if documents on the host of the URL may not be embedded.
+// Documents on the same host as the request are always allowed.
+func (h *Handler) upstream(base, u *url.URL) (http.Handler, bool) {
+	if u.Host == base.Host {
+		return h.Next, true
 	}
 
-	log.WithFields(log.Fields{
-		"child":  transaction.ID(req),
-		"parent": transaction.ID(base),
-	}).Debug("Finished a subrequest")
+	next, ok := h.Hosts[u.Host]
+	if !ok {
+		return nil, false
+	}
+
+	if next == nil {
+		next = h.Next
+	}
+
+	return next, true
+}
+
+// edgeName returns a name of the edge such as `roles[0]` to describe the subrequest.
+func edgeName(edge string, pos *int) string {
+	if pos == nil {
+		return edge
+	}
+	return fmt.Sprintf("%s[%d]", edge, *pos)
 }
 
 func errorDocument(edge string, pos *int, e *Error) *document {
+	problems.Inc(problemType(e))
+
 	return &document{
 		CacheControl: &CacheControl{
 			NoStore: true,
@@ -2,7 +2,10 @@ package embed
 
 import (
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ichiban/jesi/cache"
 	"net/url"
@@ -65,10 +68,10 @@ func TestHandler_ServeHTTP(t *testing.T) {
 					"Cache-Control":  []string{""},
 					"Content-Length": []string{"217"},
 					"Content-Type":   []string{"application/vnd.custom+json"},
-					"Etag":           []string{`W/"f7ff6c96d46da9b24176e5a56eb77f72"`},
+					"Etag":           []string{`W/"9cc9a1bea9215c4332f567f16becfe55"`},
 					"Warning":        []string{`214 - "Transformation Applied"`},
 				},
-				Body: []byte(`{"_embedded":{"foo":{"_embedded":{"bar":{"_embedded":{},"_links":{"next":{"href":"/a"},"self":{"href":"/c"}}}},"_links":{"bar":{"href":"/c"},"self":{"href":"/b"}}}},"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}`),
+				Body: []byte(`{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/c"},"self":{"href":"/b"}},"_embedded":{"bar":{"_links":{"next":{"href":"/a"},"self":{"href":"/c"}},"_embedded":{}}}}}}`),
 			},
 		},
 		{ // multiple 'with' query parameters are also fine.
@@ -106,10 +109,10 @@ func TestHandler_ServeHTTP(t *testing.T) {
 					"Cache-Control":  []string{""},
 					"Content-Length": []string{"433"},
 					"Content-Type":   []string{"application/json"},
-					"Etag":           []string{`W/"7a58566511e7b1baa3428baa18db53f6"`},
+					"Etag":           []string{`W/"4db2091d2dfe6c0676a4aff1062bb69f"`},
 					"Warning":        []string{`214 - "Transformation Applied"`},
 				},
-				Body: []byte(`{"_embedded":{"foo":{"_embedded":{"bar":{"_embedded":{"baz":{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}},"_links":{"baz":{"href":"/a"},"self":{"href":"/c"}}},"qux":{"_embedded":{"quux":{"_links":{"corge":{"href":"/a"},"self":{"href":"/e"}}}},"_links":{"quux":{"href":"/e"},"self":{"href":"/d"}}}},"_links":{"bar":{"href":"/c"},"qux":{"href":"/d"},"self":{"href":"/b"}}}},"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}`),
+				Body: []byte(`{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/c"},"qux":{"href":"/d"},"self":{"href":"/b"}},"_embedded":{"bar":{"_links":{"baz":{"href":"/a"},"self":{"href":"/c"}},"_embedded":{"baz":{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}}},"qux":{"_links":{"quux":{"href":"/e"},"self":{"href":"/d"}},"_embedded":{"quux":{"_links":{"corge":{"href":"/a"},"self":{"href":"/e"}}}}}}}}}`),
 			},
 		},
 		{ // even With header fields do.
@@ -149,10 +152,10 @@ func TestHandler_ServeHTTP(t *testing.T) {
 					"Cache-Control":  []string{""},
 					"Content-Length": []string{"433"},
 					"Content-Type":   []string{"application/json"},
-					"Etag":           []string{`W/"7a58566511e7b1baa3428baa18db53f6"`},
+					"Etag":           []string{`W/"4db2091d2dfe6c0676a4aff1062bb69f"`},
 					"Warning":        []string{`214 - "Transformation Applied"`},
 				},
-				Body: []byte(`{"_embedded":{"foo":{"_embedded":{"bar":{"_embedded":{"baz":{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}},"_links":{"baz":{"href":"/a"},"self":{"href":"/c"}}},"qux":{"_embedded":{"quux":{"_links":{"corge":{"href":"/a"},"self":{"href":"/e"}}}},"_links":{"quux":{"href":"/e"},"self":{"href":"/d"}}}},"_links":{"bar":{"href":"/c"},"qux":{"href":"/d"},"self":{"href":"/b"}}}},"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}`),
+				Body: []byte(`{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/c"},"qux":{"href":"/d"},"self":{"href":"/b"}},"_embedded":{"bar":{"_links":{"baz":{"href":"/a"},"self":{"href":"/c"}},"_embedded":{"baz":{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}}},"qux":{"_links":{"quux":{"href":"/e"},"self":{"href":"/d"}},"_embedded":{"quux":{"_links":{"corge":{"href":"/a"},"self":{"href":"/e"}}}}}}}}}`),
 			},
 		},
 		{ // or mixture of query string and With header field.
@@ -193,10 +196,10 @@ func TestHandler_ServeHTTP(t *testing.T) {
 					"Cache-Control":  []string{""},
 					"Content-Length": []string{"433"},
 					"Content-Type":   []string{"application/json"},
-					"Etag":           []string{`W/"7a58566511e7b1baa3428baa18db53f6"`},
+					"Etag":           []string{`W/"4db2091d2dfe6c0676a4aff1062bb69f"`},
 					"Warning":        []string{`214 - "Transformation Applied"`},
 				},
-				Body: []byte(`{"_embedded":{"foo":{"_embedded":{"bar":{"_embedded":{"baz":{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}},"_links":{"baz":{"href":"/a"},"self":{"href":"/c"}}},"qux":{"_embedded":{"quux":{"_links":{"corge":{"href":"/a"},"self":{"href":"/e"}}}},"_links":{"quux":{"href":"/e"},"self":{"href":"/d"}}}},"_links":{"bar":{"href":"/c"},"qux":{"href":"/d"},"self":{"href":"/b"}}}},"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}`),
+				Body: []byte(`{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/c"},"qux":{"href":"/d"},"self":{"href":"/b"}},"_embedded":{"bar":{"_links":{"baz":{"href":"/a"},"self":{"href":"/c"}},"_embedded":{"baz":{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}}},"qux":{"_links":{"quux":{"href":"/e"},"self":{"href":"/d"}},"_embedded":{"quux":{"_links":{"corge":{"href":"/a"},"self":{"href":"/e"}}}}}}}}}`),
 			},
 		},
 		{ // if the response is not JSON, it simply returns it.
@@ -240,10 +243,10 @@ func TestHandler_ServeHTTP(t *testing.T) {
 					"Cache-Control":  []string{"no-store"},
 					"Content-Length": []string{"222"},
 					"Content-Type":   []string{"application/json"},
-					"Etag":           []string{`W/"d35bc68f2f78bd21a74246002f5d724e"`},
+					"Etag":           []string{`W/"c958ff3c68cd3629aec29957f9503675"`},
 					"Warning":        []string{`214 - "Transformation Applied"`},
 				},
-				Body: []byte(`{"_embedded":{"foo":{"type":"https://ichiban.github.io/jesi/problems/response-error","title":"Response Error","status":404,"detail":"Not Found","_links":{"about":"/b"}}},"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}`),
+				Body: []byte(`{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}},"_embedded":{"foo":{"type":"https://ichiban.github.io/jesi/problems/response-error","title":"Response Error","status":404,"detail":"Not Found","_links":{"about":"/b"}}}}`),
 			},
 		},
 		{ // the resulting Cache-Control is the weakest of all.
@@ -282,10 +285,54 @@ func TestHandler_ServeHTTP(t *testing.T) {
 					"Cache-Control":  []string{"private,max-age=10"},
 					"Content-Length": []string{"217"},
 					"Content-Type":   []string{"application/json"},
-					"Etag":           []string{`W/"f7ff6c96d46da9b24176e5a56eb77f72"`},
+					"Etag":           []string{`W/"9cc9a1bea9215c4332f567f16becfe55"`},
 					"Warning":        []string{`214 - "Transformation Applied"`},
 				},
-				Body: []byte(`{"_embedded":{"foo":{"_embedded":{"bar":{"_embedded":{},"_links":{"next":{"href":"/a"},"self":{"href":"/c"}}}},"_links":{"bar":{"href":"/c"},"self":{"href":"/b"}}}},"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}`),
+				Body: []byte(`{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/c"},"self":{"href":"/b"}},"_embedded":{"bar":{"_links":{"next":{"href":"/a"},"self":{"href":"/c"}},"_embedded":{}}}}}}`),
+			},
+		},
+		{ // the resulting cache tags are the union of all.
+			req: &http.Request{
+				Method: http.MethodGet,
+				URL: &url.URL{
+					Path:     "/a",
+					RawQuery: "with=foo.bar.baz",
+				},
+			},
+			resources: map[string]*testResource{
+				"/a": {
+					header: http.Header{
+						"Content-Type":  []string{"application/json"},
+						"Surrogate-Key": []string{"a common"},
+					},
+					body: `{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}`,
+				},
+				"/b": {
+					header: http.Header{
+						"Content-Type": []string{"application/json"},
+						"Cache-Tag":    []string{"b,common"},
+					},
+					body: `{"_links":{"bar":{"href":"/c"},"self":{"href":"/b"}}}`,
+				},
+				"/c": {
+					header: http.Header{
+						"Content-Type":  []string{"application/json"},
+						"Surrogate-Key": []string{"c"},
+					},
+					body: `{"_links":{"next":{"href":"/a"},"self":{"href":"/c"}}}`,
+				},
+			},
+			resp: &cache.Representation{
+				HeaderMap: http.Header{
+					"Cache-Control":  []string{""},
+					"Cache-Tag":      []string{"a,common,b,c"},
+					"Content-Length": []string{"217"},
+					"Content-Type":   []string{"application/json"},
+					"Etag":           []string{`W/"9cc9a1bea9215c4332f567f16becfe55"`},
+					"Surrogate-Key":  []string{"a common b c"},
+					"Warning":        []string{`214 - "Transformation Applied"`},
+				},
+				Body: []byte(`{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/c"},"self":{"href":"/b"}},"_embedded":{"bar":{"_links":{"next":{"href":"/a"},"self":{"href":"/c"}},"_embedded":{}}}}}}`),
 			},
 		},
 	}
@@ -352,3 +399,414 @@ type testResource struct {
 	header http.Header
 	body   string
 }
+
+func TestHandler_ServeHTTP_diagnostics(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"/a": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"_links":{"foo":{"href":"/b"},"self":{"href":"/a"}}}`,
+			},
+			"/b": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"_links":{"bar":[{"href":"/c"}],"self":{"href":"/b"}}}`,
+			},
+			"/c": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"_links":{"self":{"href":"/c"}}}`,
+			},
+		},
+	}
+	e := Handler{
+		Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the token is sent neither to upstream nor along with subrequests.
+			if v, ok := r.Header["Jesi-Diagnostics"]; ok {
+				t.Errorf("(%s) expected no Jesi-Diagnostics, got %v", r.URL, v)
+			}
+			th.ServeHTTP(w, r)
+		}),
+		Diagnostics: &cache.Diagnostics{Token: "secret"},
+	}
+
+	var rep cache.Representation
+	e.ServeHTTP(&rep, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path:     "/a",
+			RawQuery: "with=foo.bar",
+		},
+		Header: http.Header{"Jesi-Diagnostics": []string{"secret"}},
+	})
+
+	timings := rep.HeaderMap["Server-Timing"]
+	expected := []string{`embed;desc="foo";dur=`, `embed;desc="foo.bar[0]";dur=`}
+	if len(expected) != len(timings) {
+		t.Fatalf("expected %d, got %#v", len(expected), timings)
+	}
+	for i, timing := range expected {
+		if !strings.HasPrefix(timings[i], timing) {
+			t.Errorf("(%d) expected %s, got %s", i, timing, timings[i])
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_dedup(t *testing.T) {
+	th := &countingHandler{
+		testHandler: testHandler{
+			T: t,
+			Resources: map[string]*testResource{
+				"/movies/1": {
+					header: http.Header{"Content-Type": []string{"application/json"}},
+					body:   `{"_links":{"roles":[{"href":"/roles/1"},{"href":"/roles/2"}],"self":{"href":"/movies/1"}}}`,
+				},
+				"/roles/1": {
+					header: http.Header{"Content-Type": []string{"application/json"}},
+					body:   `{"_links":{"actor":{"href":"/people/1"},"movie":{"href":"/movies/1"},"self":{"href":"/roles/1"}}}`,
+				},
+				"/roles/2": {
+					header: http.Header{"Content-Type": []string{"application/json"}},
+					body:   `{"_links":{"actor":{"href":"/people/1"},"movie":{"href":"/movies/1"},"self":{"href":"/roles/2"}}}`,
+				},
+				"/people/1": {
+					header: http.Header{"Content-Type": []string{"application/json"}},
+					body:   `{"_links":{"self":{"href":"/people/1"}}}`,
+				},
+			},
+		},
+		calls: map[string]int{},
+	}
+	e := Handler{Next: th}
+
+	var rep cache.Representation
+	e.ServeHTTP(&rep, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path:     "/movies/1",
+			RawQuery: "with=roles.actor&with=roles.movie.roles.movie",
+		},
+	})
+
+	expected := map[string]int{
+		"/movies/1": 1,
+		"/roles/1":  1,
+		"/roles/2":  1,
+		"/people/1": 1,
+	}
+	for u, n := range expected {
+		if n != th.calls[u] {
+			t.Errorf("(%s) expected %d, got %d", u, n, th.calls[u])
+		}
+	}
+
+	body := `{"_links":{"roles":[{"href":"/roles/1"},{"href":"/roles/2"}],"self":{"href":"/movies/1"}},"_embedded":{"roles":[{"_links":{"actor":{"href":"/people/1"},"movie":{"href":"/movies/1"},"self":{"href":"/roles/1"}},"_embedded":{"actor":{"_links":{"self":{"href":"/people/1"}}},"movie":{"_links":{"roles":[{"href":"/roles/1"},{"href":"/roles/2"}],"self":{"href":"/movies/1"}}}}},{"_links":{"actor":{"href":"/people/1"},"movie":{"href":"/movies/1"},"self":{"href":"/roles/2"}},"_embedded":{"actor":{"_links":{"self":{"href":"/people/1"}}},"movie":{"_links":{"roles":[{"href":"/roles/1"},{"href":"/roles/2"}],"self":{"href":"/movies/1"}}}}}]}}`
+	if body != string(rep.Body) {
+		t.Errorf("expected: %s, got: %s", body, string(rep.Body))
+	}
+}
+
+type countingHandler struct {
+	testHandler
+	sync.Mutex
+	calls map[string]int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	h.calls[r.URL.String()]++
+	h.Unlock()
+
+	h.testHandler.ServeHTTP(w, r)
+}
+
+func TestHandler_ServeHTTP_limits(t *testing.T) {
+	resources := map[string]*testResource{
+		"/a": {
+			header: http.Header{"Content-Type": []string{"application/json"}},
+			body:   `{"_links":{"foo":{"href":"/b"}}}`,
+		},
+		"/b": {
+			header: http.Header{"Content-Type": []string{"application/json"}},
+			body:   `{"_links":{"bar":{"href":"/d"}}}`,
+		},
+		"/d": {
+			header: http.Header{"Content-Type": []string{"application/json"}},
+			body:   `{}`,
+		},
+	}
+
+	testCases := []struct {
+		limits Limits
+		body   string
+	}{
+		{ // without limits, it embeds everything.
+			body: `{"_links":{"foo":{"href":"/b"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/d"}},"_embedded":{"bar":{}}}}}`,
+		},
+		{ // it doesn't follow edges deeper than the max depth.
+			limits: Limits{MaxDepth: 1},
+			body:   `{"_links":{"foo":{"href":"/b"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/d"}},"_embedded":{"bar":{"type":"https://ichiban.github.io/jesi/problems/depth-limit-exceeded","title":"Depth Limit Exceeded","detail":"can't embed documents deeper than 1","_links":{"about":"/d"}}}}}}`,
+		},
+		{ // it doesn't make subrequests more than the max.
+			limits: Limits{MaxSubrequests: 1},
+			body:   `{"_links":{"foo":{"href":"/b"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/d"}},"_embedded":{"bar":{"type":"https://ichiban.github.io/jesi/problems/subrequest-limit-exceeded","title":"Subrequest Limit Exceeded","detail":"can't make more than 1 subrequests","_links":{"about":"/d"}}}}}}`,
+		},
+		{ // it doesn't embed documents beyond the max size.
+			limits: Limits{MaxSize: 64},
+			body:   `{"_links":{"foo":{"href":"/b"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/d"}},"_embedded":{"bar":{"type":"https://ichiban.github.io/jesi/problems/size-limit-exceeded","title":"Size Limit Exceeded","detail":"can't embed documents larger than 64 bytes in total","_links":{"about":"/d"}}}}}}`,
+		},
+		{ // the max concurrency doesn't change the result.
+			limits: Limits{MaxConcurrency: 1},
+			body:   `{"_links":{"foo":{"href":"/b"}},"_embedded":{"foo":{"_links":{"bar":{"href":"/d"}},"_embedded":{"bar":{}}}}}`,
+		},
+	}
+
+	for i, tc := range testCases {
+		e := Handler{
+			Next:   &testHandler{T: t, Resources: resources},
+			Limits: tc.limits,
+		}
+
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     "/a",
+				RawQuery: "with=foo.bar",
+			},
+		})
+
+		if tc.body != string(rep.Body) {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.body, string(rep.Body))
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_timeout(t *testing.T) {
+	resources := map[string]*testResource{
+		"/a": {
+			header: http.Header{"Content-Type": []string{"application/json"}},
+			body:   `{"_links":{"foo":{"href":"/b"},"bar":{"href":"/slow"}}}`,
+		},
+		"/b": {
+			header: http.Header{"Content-Type": []string{"application/json"}},
+			body:   `{"_links":{"baz":{"href":"/slow"}}}`,
+		},
+		"/slow": {
+			header: http.Header{"Content-Type": []string{"application/json"}},
+			body:   `{}`,
+		},
+	}
+
+	testCases := []struct {
+		limits Limits
+		with   string
+		body   string
+	}{
+		{ // a slow subrequest is embedded as an error.
+			limits: Limits{SubrequestTimeout: 50 * time.Millisecond},
+			with:   "foo&with=bar",
+			body:   `{"_links":{"foo":{"href":"/b"},"bar":{"href":"/slow"}},"_embedded":{"bar":{"type":"https://ichiban.github.io/jesi/problems/timeout","title":"Timeout","detail":"context deadline exceeded","_links":{"about":"/slow"}},"foo":{"_links":{"baz":{"href":"/slow"}}}}}`,
+		},
+		{ // unfinished edges are embedded as errors after the timeout.
+			limits: Limits{Timeout: 50 * time.Millisecond},
+			with:   "foo.baz",
+			body:   `{"_links":{"foo":{"href":"/b"},"bar":{"href":"/slow"}},"_embedded":{"foo":{"_links":{"baz":{"href":"/slow"}},"_embedded":{"baz":{"type":"https://ichiban.github.io/jesi/problems/timeout","title":"Timeout","detail":"context deadline exceeded","_links":{"about":"/slow"}}}}}}`,
+		},
+	}
+
+	for i, tc := range testCases {
+		th := &slowHandler{
+			testHandler: testHandler{T: t, Resources: resources},
+			release:     make(chan struct{}),
+		}
+		e := Handler{
+			Next:   th,
+			Limits: tc.limits,
+		}
+
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     "/a",
+				RawQuery: "with=" + tc.with,
+			},
+		})
+		close(th.release)
+
+		if tc.body != string(rep.Body) {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.body, string(rep.Body))
+		}
+	}
+}
+
+// slowHandler doesn't respond to /slow until it's released regardless of the request context.
+type slowHandler struct {
+	testHandler
+	release chan struct{}
+}
+
+func (h *slowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/slow" {
+		<-h.release
+	}
+
+	h.testHandler.ServeHTTP(w, r)
+}
+
+func TestHandler_ServeHTTP_templated(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"/a": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"_links":{"search":{"href":"/search{?q}","templated":true,"name":"text"}}}`,
+			},
+			"/b": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"_links":{"curies":[{"name":"ex","href":"http://example.com/rels/{rel}","templated":true}],"http://example.com/rels/search":{"href":"/search{?q}","templated":true}}}`,
+			},
+			"/search?q=foo%20bar": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"q":"foo bar"}`,
+			},
+		},
+	}
+
+	testCases := []struct {
+		path  string
+		query string
+		body  string
+	}{
+		{ // templated links are expanded with the variables.
+			path:  "/a",
+			query: "with=search&search.q=foo+bar",
+			body:  `{"_links":{"search":{"href":"/search{?q}","templated":true,"name":"text"}},"_embedded":{"search":{"q":"foo bar"}}}`,
+		},
+		{ // templated links are skipped without the variables.
+			path:  "/a",
+			query: "with=search",
+			body:  `{"_links":{"search":{"href":"/search{?q}","templated":true,"name":"text"}},"_embedded":{}}`,
+		},
+		{ // filtered edges share the variables of the rel.
+			path:  "/a",
+			query: "with=search[text]&search.q=foo+bar",
+			body:  `{"_links":{"search":{"href":"/search{?q}","templated":true,"name":"text"}},"_embedded":{"search":{"q":"foo bar"}}}`,
+		},
+		{ // or specify them with the filter.
+			path:  "/a",
+			query: "with=search[text]&search[text].q=foo+bar",
+			body:  `{"_links":{"search":{"href":"/search{?q}","templated":true,"name":"text"}},"_embedded":{"search":{"q":"foo bar"}}}`,
+		},
+		{ // paginated edges share the variables of the rel.
+			path:  "/a",
+			query: "with=search*&search.q=foo+bar",
+			body:  `{"_links":{"search":{"href":"/search{?q}","templated":true,"name":"text"}},"_embedded":{"search":[{"q":"foo bar"}]}}`,
+		},
+		{ // CURIE edges are expanded with the variables of the CURIE.
+			path:  "/b",
+			query: "with=ex:search&ex:search.q=foo+bar",
+			body:  `{"_links":{"curies":[{"name":"ex","href":"http://example.com/rels/{rel}","templated":true}],"http://example.com/rels/search":{"href":"/search{?q}","templated":true}},"_embedded":{"http://example.com/rels/search":{"q":"foo bar"}}}`,
+		},
+	}
+
+	for i, tc := range testCases {
+		e := Handler{Next: th}
+
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     tc.path,
+				RawQuery: tc.query,
+			},
+		})
+
+		if tc.body != string(rep.Body) {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.body, string(rep.Body))
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_hosts(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"http://www.example.com/movies/1": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"_links":{"poster":{"href":"poster"},"roles":{"href":"roles"},"studio":{"href":"http://studio.example.com/studios/1"},"other":{"href":"http://other.example.com/1"}}}`,
+			},
+			"http://www.example.com/movies/roles": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"count":2}`,
+			},
+			"http://www.example.com/movies/poster": {
+				header: http.Header{"Content-Type": []string{"image/png"}},
+				body:   `PNG`,
+			},
+		},
+	}
+	studio := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"http://studio.example.com/studios/1": {
+				header: http.Header{"Content-Type": []string{"application/json"}},
+				body:   `{"name":"Miramax"}`,
+			},
+		},
+	}
+	headers := map[string]http.Header{}
+	var mu sync.Mutex
+	record := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			headers[r.URL.String()] = r.Header
+			mu.Unlock()
+			next.ServeHTTP(w, r)
+		})
+	}
+	e := Handler{
+		Next: record(th),
+		Hosts: map[string]http.Handler{
+			"studio.example.com": record(studio),
+		},
+	}
+
+	var rep cache.Representation
+	e.ServeHTTP(&rep, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Scheme:   "http",
+			Host:     "www.example.com",
+			Path:     "/movies/1",
+			RawQuery: "with=poster&with=roles&with=studio&with=other",
+		},
+		Header: http.Header{
+			"Accept":              []string{"application/json"},
+			"Authorization":       []string{"Bearer secret"},
+			"Cookie":              []string{"sid=1"},
+			"Proxy-Authorization": []string{"Basic c2VjcmV0"},
+		},
+	})
+
+	body := `{"_links":{"poster":{"href":"poster"},"roles":{"href":"roles"},"studio":{"href":"http://studio.example.com/studios/1"},"other":{"href":"http://other.example.com/1"}},"_embedded":{"other":{"type":"https://ichiban.github.io/jesi/problems/disallowed-host","title":"Disallowed Host","detail":"can't embed documents on other.example.com","_links":{"about":"http://other.example.com/1"}},"poster":{"type":"https://ichiban.github.io/jesi/problems/unsupported-media-type","title":"Unsupported Media Type","detail":"can't embed \"image/png\"","_links":{"about":"poster"}},"roles":{"count":2},"studio":{"name":"Miramax"}}}`
+	if body != string(rep.Body) {
+		t.Errorf("expected: %s, got: %s", body, string(rep.Body))
+	}
+
+	// credentials are sent only to the same host.
+	for u, credentials := range map[string]bool{
+		"http://www.example.com/movies/roles": true,
+		"http://studio.example.com/studios/1": false,
+	} {
+		h := headers[u]
+		if a := h.Get("Accept"); a != "application/json" {
+			t.Errorf("(%s) expected application/json, got %s", u, a)
+		}
+		for _, f := range []string{"Authorization", "Cookie", "Proxy-Authorization"} {
+			if _, ok := h[f]; ok != credentials {
+				t.Errorf("(%s) expected %s to be sent: %t, got %v", u, f, credentials, h)
+			}
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package embed
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	include = "include"
+
+	jsonAPIData          = "data"
+	jsonAPIIncluded      = "included"
+	jsonAPIRelationships = "relationships"
+	jsonAPILinks         = "links"
+	jsonAPIRelated       = "related"
+	jsonAPIType          = "type"
+	jsonAPIID            = "id"
+	jsonAPIMeta          = "meta"
+	jsonAPIErrors        = "errors"
+)
+
+// stripIncludes removes `include` query parameter from the request and returns the embedding spec of it.
+func stripIncludes(req *http.Request) specifier {
+	spec := specifier{}
+	q := req.URL.Query()
+	for _, is := range q[include] {
+		for _, i := range strings.Split(is, ",") {
+			spec.add(strings.Split(i, "."))
+		}
+	}
+
+	q.Del(include)
+	req.URL.RawQuery = q.Encode()
+
+	return spec
+}
+
+// jsonAPI fetches documents linked by `related` links of relationships of the primary data of the JSON:API document
+// and adds their resources to `included` without duplicates.
+// Relationships without resource linkage get `data` of resource identifier objects of the fetched resources.
+// Errors are added to `errors` in `meta` since a document with `data` can't have top-level `errors`.
+type jsonAPI struct{}
+
+func (jsonAPI) embed(h *Handler, base *http.Request, x *expansion, doc *document, spec specifier) {
+	if len(spec) == 0 {
+		return
+	}
+
+	top, ok := members(doc.data)
+	if !ok {
+		return
+	}
+
+	_, many := top[jsonAPIData].([]interface{})
+	resources := primaryData(top)
+	included := resourceObjects(top[jsonAPIIncluded])
+
+	seen := map[resourceID]struct{}{}
+	for _, r := range resources {
+		seen[newResourceID(r)] = struct{}{}
+	}
+	for _, r := range included {
+		seen[newResourceID(r)] = struct{}{}
+	}
+
+	ch := make(chan *document, len(spec))
+	defer close(ch)
+
+	count := 0
+	for i, r := range resources {
+		var pos *int
+		if many {
+			i := i
+			pos = &i
+		}
+
+		rels, _ := members(r.members[jsonAPIRelationships])
+		for edge, next := range spec {
+			rel, ok := members(rels[edge])
+			if !ok {
+				continue
+			}
+
+			l, ok := relatedLink(rel)
+			if !ok {
+				continue
+			}
+
+			count++
+			go h.fetch(base, x, doc.path, edge, pos, l, nil, next, ch)
+		}
+	}
+
+	subs := make([]*document, count)
+	for i := range subs {
+		subs[i] = <-ch
+	}
+
+	// the order of subrequests doesn't affect the resulting document.
+	sort.Slice(subs, func(i, j int) bool {
+		a, b := subs[i], subs[j]
+		if a.pos == nil || b.pos == nil || *a.pos == *b.pos {
+			return a.edge < b.edge
+		}
+		return *a.pos < *b.pos
+	})
+
+	var errs []interface{}
+	for _, sub := range subs {
+		doc.merge(sub)
+
+		switch data := sub.data.(type) {
+		case *Error:
+			errs = append(errs, data)
+		case *object:
+			link(resources, sub, data.members[jsonAPIData])
+			for _, r := range append(primaryData(data.members), resourceObjects(data.members[jsonAPIIncluded])...) {
+				id := newResourceID(r)
+				if _, ok := seen[id]; ok && id.ID != "" {
+					continue
+				}
+				seen[id] = struct{}{}
+				included = append(included, r)
+			}
+			if meta, ok := members(data.members[jsonAPIMeta]); ok {
+				es, _ := meta[jsonAPIErrors].([]interface{})
+				errs = append(errs, es...)
+			}
+		}
+	}
+
+	if len(included) > 0 {
+		is := make([]interface{}, len(included))
+		for i, r := range included {
+			is[i] = r
+		}
+		top[jsonAPIIncluded] = is
+	}
+
+	if len(errs) > 0 {
+		meta, ok := members(top[jsonAPIMeta])
+		if !ok {
+			o := newObject()
+			top[jsonAPIMeta] = o
+			meta = o.members
+		}
+		es, _ := meta[jsonAPIErrors].([]interface{})
+		meta[jsonAPIErrors] = append(es, errs...)
+	}
+}
+
+// link adds resource linkage of the fetched primary data to the relationship of the resource unless it already has one.
+func link(resources []*object, sub *document, data interface{}) {
+	i := 0
+	if sub.pos != nil {
+		i = *sub.pos
+	}
+	rels, _ := members(resources[i].members[jsonAPIRelationships])
+	rel, ok := members(rels[sub.edge])
+	if !ok {
+		return
+	}
+	if _, ok := rel[jsonAPIData]; ok {
+		return
+	}
+
+	switch d := data.(type) {
+	case *object:
+		rel[jsonAPIData] = identifier(d)
+	case []interface{}:
+		ids := []interface{}{}
+		for _, r := range resourceObjects(d) {
+			ids = append(ids, identifier(r))
+		}
+		rel[jsonAPIData] = ids
+	case nil:
+		rel[jsonAPIData] = nil
+	}
+}
+
+// identifier returns the resource identifier object of the resource object.
+func identifier(r *object) *object {
+	id := newObject()
+	id.keys = []string{jsonAPIType, jsonAPIID}
+	id.members[jsonAPIType] = r.members[jsonAPIType]
+	id.members[jsonAPIID] = r.members[jsonAPIID]
+	return id
+}
+
+// resourceID identifies a resource object in a JSON:API document.
+type resourceID struct {
+	Type string
+	ID   string
+}
+
+func newResourceID(r *object) resourceID {
+	t, _ := r.members[jsonAPIType].(string)
+	id, _ := r.members[jsonAPIID].(string)
+	return resourceID{Type: t, ID: id}
+}
+
+// primaryData returns resource objects in `data` whether it's a single resource object or an array of them.
+func primaryData(doc map[string]interface{}) []*object {
+	if r, ok := doc[jsonAPIData].(*object); ok {
+		return []*object{r}
+	}
+	return resourceObjects(doc[jsonAPIData])
+}
+
+func resourceObjects(v interface{}) []*object {
+	vs, _ := v.([]interface{})
+	var rs []*object
+	for _, v := range vs {
+		if r, ok := v.(*object); ok {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// relatedLink returns `related` link of the relationship as a link object.
+// The link is either a string or a link object with `href`.
+func relatedLink(rel map[string]interface{}) (map[string]interface{}, bool) {
+	ls, ok := members(rel[jsonAPILinks])
+	if !ok {
+		return nil, false
+	}
+
+	switch l := ls[jsonAPIRelated].(type) {
+	case string:
+		return map[string]interface{}{href: l}, true
+	case *object:
+		return l.members, true
+	default:
+		return nil, false
+	}
+}
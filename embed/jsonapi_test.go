@@ -0,0 +1,79 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_ServeHTTP_jsonAPI(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"/articles": {
+				header: http.Header{"Content-Type": []string{"application/vnd.api+json"}},
+				body:   `{"data":[{"type":"articles","id":"1","relationships":{"author":{"links":{"related":"/articles/1/author"}},"comments":{"links":{"related":{"href":"/articles/1/comments"}}}}},{"type":"articles","id":"2","relationships":{"author":{"links":{"related":"/articles/2/author"}}}}]}`,
+			},
+			"/articles/2": {
+				header: http.Header{"Content-Type": []string{"application/vnd.api+json"}},
+				body:   `{"data":{"type":"articles","id":"2","relationships":{"author":{"data":{"type":"people","id":"9"},"links":{"related":"/articles/2/author"}}}}}`,
+			},
+			"/articles/1/author": {
+				header: http.Header{"Content-Type": []string{"application/vnd.api+json"}},
+				body:   `{"data":{"type":"people","id":"9"}}`,
+			},
+			"/articles/2/author": {
+				header: http.Header{"Content-Type": []string{"application/vnd.api+json"}},
+				body:   `{"data":{"type":"people","id":"9"}}`,
+			},
+			"/articles/1/comments": {
+				header: http.Header{"Content-Type": []string{"application/vnd.api+json"}},
+				body:   `{"data":[{"type":"comments","id":"5","relationships":{"author":{"links":{"related":"/comments/5/author"}}}}]}`,
+			},
+		},
+	}
+
+	testCases := []struct {
+		path  string
+		query string
+		body  string
+	}{
+		{ // related resources are included without duplicates and linked by resource identifiers.
+			path:  "/articles",
+			query: "include=author",
+			body:  `{"data":[{"type":"articles","id":"1","relationships":{"author":{"links":{"related":"/articles/1/author"},"data":{"type":"people","id":"9"}},"comments":{"links":{"related":{"href":"/articles/1/comments"}}}}},{"type":"articles","id":"2","relationships":{"author":{"links":{"related":"/articles/2/author"},"data":{"type":"people","id":"9"}}}}],"included":[{"type":"people","id":"9"}]}`,
+		},
+		{ // nested related resources are also included and errors are in meta.
+			path:  "/articles",
+			query: "include=comments.author",
+			body:  `{"data":[{"type":"articles","id":"1","relationships":{"author":{"links":{"related":"/articles/1/author"}},"comments":{"links":{"related":{"href":"/articles/1/comments"}},"data":[{"type":"comments","id":"5"}]}}},{"type":"articles","id":"2","relationships":{"author":{"links":{"related":"/articles/2/author"}}}}],"included":[{"type":"comments","id":"5","relationships":{"author":{"links":{"related":"/comments/5/author"}}}}],"meta":{"errors":[{"type":"https://ichiban.github.io/jesi/problems/response-error","title":"Response Error","status":404,"detail":"Not Found","_links":{"about":"/comments/5/author"}}]}}`,
+		},
+		{ // resource linkage of the upstream document is kept.
+			path:  "/articles/2",
+			query: "include=author",
+			body:  `{"data":{"type":"articles","id":"2","relationships":{"author":{"data":{"type":"people","id":"9"},"links":{"related":"/articles/2/author"}}}},"included":[{"type":"people","id":"9"}]}`,
+		},
+	}
+
+	for i, tc := range testCases {
+		e := Handler{
+			Next:    th,
+			JSONAPI: true,
+		}
+
+		var rep cache.Representation
+		e.ServeHTTP(&rep, &http.Request{
+			Method: http.MethodGet,
+			URL: &url.URL{
+				Path:     tc.path,
+				RawQuery: tc.query,
+			},
+		})
+
+		if tc.body != string(rep.Body) {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.body, string(rep.Body))
+		}
+	}
+}
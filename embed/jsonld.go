@@ -0,0 +1,70 @@
+package embed
+
+import (
+	"net/http"
+)
+
+const (
+	jsonLDID = "@id"
+)
+
+// jsonLD replaces node references (node objects only with `@id`) with the referenced nodes of JSON-LD documents.
+// Edges match properties of the node.
+type jsonLD struct{}
+
+func (jsonLD) embed(h *Handler, base *http.Request, x *expansion, doc *document, spec specifier) {
+	if len(spec) == 0 {
+		return
+	}
+
+	node, ok := members(doc.data)
+	if !ok {
+		return
+	}
+
+	ch := make(chan *document, len(spec))
+	defer close(ch)
+
+	count := 0
+	for edge, next := range spec {
+		switch v := node[edge].(type) {
+		case *object:
+			id, ok := nodeReference(v.members)
+			if !ok {
+				continue
+			}
+			count++
+			go h.fetch(base, x, doc.path, edge, nil, map[string]interface{}{href: id}, nil, next, ch)
+		case []interface{}:
+			for i, v := range v {
+				v, ok := members(v)
+				if !ok {
+					continue
+				}
+				id, ok := nodeReference(v)
+				if !ok {
+					continue
+				}
+				i := i
+				count++
+				go h.fetch(base, x, doc.path, edge, &i, map[string]interface{}{href: id}, nil, next, ch)
+			}
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		sub := <-ch
+		if sub.pos == nil {
+			node[sub.edge] = sub.data
+		} else {
+			node[sub.edge].([]interface{})[*sub.pos] = sub.data
+		}
+		doc.merge(sub)
+	}
+}
+
+// nodeReference returns the IRI of the node object if it's a node reference.
+func nodeReference(v map[string]interface{}) (string, bool) {
+	id, ok := v[jsonLDID].(string)
+	return id, ok && len(v) == 1
+}
@@ -0,0 +1,44 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_ServeHTTP_jsonLD(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"/movies/1": {
+				header: http.Header{"Content-Type": []string{"application/ld+json"}},
+				body:   `{"@id":"/movies/1","director":{"@id":"/people/1"},"actor":[{"@id":"/people/2"},{"@id":"/people/3","name":"Uma Thurman"}]}`,
+			},
+			"/people/1": {
+				header: http.Header{"Content-Type": []string{"application/ld+json"}},
+				body:   `{"@id":"/people/1","name":"Quentin Tarantino"}`,
+			},
+			"/people/2": {
+				header: http.Header{"Content-Type": []string{"application/ld+json"}},
+				body:   `{"@id":"/people/2","name":"John Travolta"}`,
+			},
+		},
+	}
+	e := Handler{Next: th}
+
+	var rep cache.Representation
+	e.ServeHTTP(&rep, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path:     "/movies/1",
+			RawQuery: "with=director&with=actor",
+		},
+	})
+
+	body := `{"@id":"/movies/1","director":{"@id":"/people/1","name":"Quentin Tarantino"},"actor":[{"@id":"/people/2","name":"John Travolta"},{"@id":"/people/3","name":"Uma Thurman"}]}`
+	if body != string(rep.Body) {
+		t.Errorf("expected: %s, got: %s", body, string(rep.Body))
+	}
+}
@@ -0,0 +1,30 @@
+package embed
+
+import (
+	"time"
+)
+
+// Limits restricts how much a single request can embed so that clients can't amplify load on the upstream server.
+// Zero means unlimited.
+type Limits struct {
+	// MaxDepth is the maximum number of dot separated edges to follow.
+	MaxDepth int
+
+	// MaxSubrequests is the maximum number of subrequests made for a request.
+	MaxSubrequests int
+
+	// MaxConcurrency is the maximum number of subrequests in flight for a request.
+	MaxConcurrency int
+
+	// MaxPages is the maximum number of pages to follow by `next` links for a paginated edge.
+	MaxPages int
+
+	// MaxSize is the maximum size of the resulting document in bytes.
+	MaxSize int
+
+	// SubrequestTimeout is the maximum duration to wait for each subrequest.
+	SubrequestTimeout time.Duration
+
+	// Timeout is the maximum duration of embedding for a request.
+	Timeout time.Duration
+}
@@ -0,0 +1,19 @@
+package embed
+
+import (
+	"path"
+
+	"github.com/ichiban/jesi/metrics"
+)
+
+var (
+	subrequests        = metrics.NewCounter("jesi_embed_subrequests_total", "Number of subrequests by status code.", "code")
+	subrequestDuration = metrics.NewHistogram("jesi_embed_subrequest_duration_seconds", "Latencies of subrequests.")
+	coalesced          = metrics.NewCounter("jesi_embed_coalesced_subrequests_total", "Number of subrequests served by fetches of other links.")
+	problems           = metrics.NewCounter("jesi_embed_errors_total", "Number of embedding errors by problem type.", "type")
+)
+
+// problemType returns the last segment of the problem type URI such as `response-error`.
+func problemType(e *Error) string {
+	return path.Base(e.Type)
+}
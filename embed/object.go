@@ -0,0 +1,179 @@
+package embed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+)
+
+// object is a JSON object which keeps the order of the members in the upstream document.
+// Members which aren't in the upstream document such as `_embedded` follow them in lexical order.
+type object struct {
+	keys    []string
+	members map[string]interface{}
+}
+
+func newObject() *object {
+	return &object{
+		members: map[string]interface{}{},
+	}
+}
+
+// members returns the members of the value and true if it's an object.
+func members(v interface{}) (map[string]interface{}, bool) {
+	o, ok := v.(*object)
+	if !ok {
+		return nil, false
+	}
+	return o.members, true
+}
+
+var _ json.Marshaler = (*object)(nil)
+
+// MarshalJSON returns the JSON encoding of the object with the members in order.
+func (o *object) MarshalJSON() ([]byte, error) {
+	return marshal(o)
+}
+
+// orderedKeys returns the keys of the existing members in the upstream order followed by the others in lexical order.
+func (o *object) orderedKeys() []string {
+	keys := make([]string, 0, len(o.members))
+	known := make(map[string]struct{}, len(o.keys))
+	for _, k := range o.keys {
+		known[k] = struct{}{}
+		if _, ok := o.members[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+
+	var others []string
+	for k := range o.members {
+		if _, ok := known[k]; !ok {
+			others = append(others, k)
+		}
+	}
+	sort.Strings(others)
+
+	return append(keys, others...)
+}
+
+// decode parses the JSON document. Objects are decoded into *object and numbers into json.Number
+// so that the document can be encoded back without reordering members or losing precision.
+func decode(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	v, err := decodeValue(d)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing but whitespaces follows the top-level value.
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+
+	return v, nil
+}
+
+func decodeValue(d *json.Decoder) (interface{}, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		o := newObject()
+		for d.More() {
+			t, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			k := t.(string)
+
+			v, err := decodeValue(d)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := o.members[k]; !ok {
+				o.keys = append(o.keys, k)
+			}
+			o.members[k] = v
+		}
+		if _, err := d.Token(); err != nil {
+			return nil, err
+		}
+		return o, nil
+	case json.Delim('['):
+		a := []interface{}{}
+		for d.More() {
+			v, err := decodeValue(d)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		if _, err := d.Token(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	default:
+		return t, nil
+	}
+}
+
+// encodeJSON writes the JSON encoding of the value without escaping HTML characters.
+func encodeJSON(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case *object:
+		b.WriteByte('{')
+		for i, k := range v.orderedKeys() {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := encodeJSON(b, k); err != nil {
+				return err
+			}
+			b.WriteByte(':')
+			if err := encodeJSON(b, v.members[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+		return nil
+	case []interface{}:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := encodeJSON(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+		return nil
+	default:
+		e := json.NewEncoder(b)
+		e.SetEscapeHTML(false)
+		if err := e.Encode(v); err != nil {
+			return err
+		}
+		// Encode terminates the value with a newline.
+		b.Truncate(b.Len() - 1)
+		return nil
+	}
+}
+
+// marshal returns the JSON encoding of the document.
+func marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := encodeJSON(&b, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
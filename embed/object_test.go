@@ -0,0 +1,89 @@
+package embed
+
+import (
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	testCases := []struct {
+		doc  string
+		err  bool
+		json string
+	}{
+		{ // members keep the order and numbers keep the precision.
+			doc:  `{"id":12345678901234567890,"price":1.50,"_links":{"self":{"href":"/a"}},"tags":[1e3,true,null,"<&>"]}`,
+			json: `{"id":12345678901234567890,"price":1.50,"_links":{"self":{"href":"/a"}},"tags":[1e3,true,null,"<&>"]}`,
+		},
+		{ // insignificant whitespaces are removed.
+			doc:  "{\n  \"b\": 1,\n  \"a\": [ 2, 3 ]\n}\n",
+			json: `{"b":1,"a":[2,3]}`,
+		},
+		{ // the last one of the duplicate members wins.
+			doc:  `{"b":1,"a":2,"b":3}`,
+			json: `{"b":3,"a":2}`,
+		},
+		{
+			doc: `{"a":1}{"b":2}`,
+			err: true,
+		},
+		{
+			doc: `{"a":1`,
+			err: true,
+		},
+		{
+			doc: `{"a":1} garbage`,
+			err: true,
+		},
+		{
+			doc: `{"a":1}}`,
+			err: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		v, err := decode([]byte(tc.doc))
+		if tc.err {
+			if err == nil {
+				t.Errorf("(%d) expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("(%d) decode() failed: %v", i, err)
+			continue
+		}
+
+		b, err := marshal(v)
+		if err != nil {
+			t.Errorf("(%d) marshal() failed: %v", i, err)
+			continue
+		}
+
+		if tc.json != string(b) {
+			t.Errorf("(%d) expected: %s, got: %s", i, tc.json, string(b))
+		}
+	}
+}
+
+func TestObject_MarshalJSON(t *testing.T) {
+	v, err := decode([]byte(`{"_links":{"self":{"href":"/a"}},"title":"Pulp Fiction","year":1994}`))
+	if err != nil {
+		t.Fatalf("decode() failed: %v", err)
+	}
+
+	o := v.(*object)
+	delete(o.members, "year")
+	o.members["rating"] = "R"
+	o.members[embedded] = newObject()
+
+	b, err := o.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %v", err)
+	}
+
+	// new members follow the existing ones in lexical order.
+	expected := `{"_links":{"self":{"href":"/a"}},"title":"Pulp Fiction","_embedded":{},"rating":"R"}`
+	if expected != string(b) {
+		t.Errorf("expected: %s, got: %s", expected, string(b))
+	}
+}
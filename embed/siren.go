@@ -0,0 +1,131 @@
+package embed
+
+import (
+	"net/http"
+	"sort"
+)
+
+const (
+	sirenEntities   = "entities"
+	sirenLinks      = "links"
+	sirenRel        = "rel"
+	sirenClass      = "class"
+	sirenProperties = "properties"
+)
+
+// siren replaces sub-entities which are embedded links with the linked entities
+// and adds entities linked by `links` to `entities` of Siren documents.
+// Edges match one of `rel` of the sub-entities and the links.
+type siren struct{}
+
+func (siren) embed(h *Handler, base *http.Request, x *expansion, doc *document, spec specifier) {
+	if len(spec) == 0 {
+		return
+	}
+
+	entity, ok := members(doc.data)
+	if !ok {
+		return
+	}
+
+	entities, _ := entity[sirenEntities].([]interface{})
+	ls, _ := entity[sirenLinks].([]interface{})
+
+	// sub-entities and links matching more than one edge are embedded once for the first edge in order.
+	edges := make([]string, 0, len(spec))
+	for edge := range spec {
+		edges = append(edges, edge)
+	}
+	sort.Strings(edges)
+
+	type target struct {
+		edge string
+		link map[string]interface{}
+	}
+
+	targets := map[int]target{}
+	for _, edge := range edges {
+		for i, e := range entities {
+			e, ok := members(e)
+			if !ok || !hasRel(e, edge) {
+				continue
+			}
+
+			// embedded representations are already there.
+			if _, ok := e[href]; !ok {
+				continue
+			}
+
+			if _, ok := targets[i]; !ok {
+				targets[i] = target{edge: edge, link: e}
+			}
+		}
+	}
+
+	linked := map[int]struct{}{}
+	for _, edge := range edges {
+		for i, l := range ls {
+			l, ok := members(l)
+			if !ok || !hasRel(l, edge) {
+				continue
+			}
+
+			if _, ok := linked[i]; ok {
+				continue
+			}
+			linked[i] = struct{}{}
+
+			targets[len(entities)] = target{edge: edge, link: l}
+			entities = append(entities, nil)
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+
+	ch := make(chan *document, len(targets))
+	defer close(ch)
+
+	for i, t := range targets {
+		i := i
+		go h.fetch(base, x, doc.path, t.edge, &i, t.link, nil, spec[t.edge], ch)
+	}
+
+	for range targets {
+		sub := <-ch
+		entities[*sub.pos] = subEntity(sub.data, targets[*sub.pos].link[sirenRel])
+		doc.merge(sub)
+	}
+
+	entity[sirenEntities] = entities
+}
+
+// hasRel returns true if the sub-entity or link has the relation.
+func hasRel(l map[string]interface{}, rel string) bool {
+	rels, _ := l[sirenRel].([]interface{})
+	for _, r := range rels {
+		if r == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// subEntity returns an embedded representation with the relation. Errors are in `properties` of `error` class entities.
+func subEntity(data interface{}, rel interface{}) interface{} {
+	switch data := data.(type) {
+	case *object:
+		data.members[sirenRel] = rel
+		return data
+	default:
+		return &object{
+			keys: []string{sirenClass, sirenRel, sirenProperties},
+			members: map[string]interface{}{
+				sirenClass:      []interface{}{"error"},
+				sirenRel:        rel,
+				sirenProperties: data,
+			},
+		}
+	}
+}
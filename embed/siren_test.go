@@ -0,0 +1,44 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_ServeHTTP_siren(t *testing.T) {
+	th := &testHandler{
+		T: t,
+		Resources: map[string]*testResource{
+			"/orders/42": {
+				header: http.Header{"Content-Type": []string{"application/vnd.siren+json"}},
+				body:   `{"class":["order"],"entities":[{"class":["items"],"rel":["order-items"],"href":"/orders/42/items"}],"links":[{"rel":["self"],"href":"/orders/42"},{"rel":["customer"],"href":"/customers/7"}]}`,
+			},
+			"/orders/42/items": {
+				header: http.Header{"Content-Type": []string{"application/vnd.siren+json"}},
+				body:   `{"class":["items"],"properties":{"count":2}}`,
+			},
+			"/customers/7": {
+				header: http.Header{"Content-Type": []string{"application/vnd.siren+json"}},
+				body:   `{"class":["customer"],"properties":{"name":"Pete"}}`,
+			},
+		},
+	}
+	e := Handler{Next: th}
+
+	var rep cache.Representation
+	e.ServeHTTP(&rep, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path:     "/orders/42",
+			RawQuery: "with=order-items&with=customer&with=unknown",
+		},
+	})
+
+	body := `{"class":["order"],"entities":[{"class":["items"],"properties":{"count":2},"rel":["order-items"]},{"class":["customer"],"properties":{"name":"Pete"},"rel":["customer"]}],"links":[{"rel":["self"],"href":"/orders/42"},{"rel":["customer"],"href":"/customers/7"}]}`
+	if body != string(rep.Body) {
+		t.Errorf("expected: %s, got: %s", body, string(rep.Body))
+	}
+}
@@ -0,0 +1,217 @@
+package embed
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/ichiban/jesi/cache"
+	"github.com/ichiban/jesi/transaction"
+	log "github.com/sirupsen/logrus"
+)
+
+// stream writes the HAL+JSON document before the embedded documents of the targets are fetched and then writes each of them
+// as soon as it and the preceding ones in `_embedded` are fetched.
+// Since the cache policies of the embedded documents are unknown when the header fields are written,
+// the response requires revalidation and has no ETag. Server-Timing of the embedded documents is sent as a trailer.
+func (h *Handler) stream(w http.ResponseWriter, base *http.Request, x *expansion, rep *cache.Representation, doc *document, es map[string]interface{}, ts []linkTarget, fields specifier) {
+	ch := make(chan *document, len(ts))
+	for _, t := range ts {
+		go h.fetch(base, x, doc.path, t.rel, t.pos, t.link, t.vars, t.next, ch)
+	}
+
+	project(doc.data, fields)
+
+	cc := *doc.CacheControl
+	cc.NoCache = true
+
+	header := w.Header()
+	for k, vs := range rep.HeaderMap {
+		header[k] = vs
+	}
+	delete(header, contentLengthField)
+	delete(header, etagField)
+	delete(header, expiresField)
+	header[cacheControlField] = []string{cc.String()}
+	cache.SetTags(header, doc.tags)
+	if _, ok := header[warningField]; !ok {
+		header.Set(warningField, `214 - "Transformation Applied"`)
+	}
+	w.WriteHeader(rep.StatusCode)
+
+	s := streamer{
+		w:       w,
+		doc:     doc,
+		fields:  fields,
+		ch:      ch,
+		pending: make(map[string]struct{}, len(ts)),
+		fetched: make(map[streamKey]*document, len(ts)),
+	}
+	for _, t := range ts {
+		s.pending[t.rel] = struct{}{}
+	}
+	if err := s.writeDocument(es); err != nil {
+		log.WithFields(log.Fields{
+			"id":    transaction.ID(base),
+			"error": err,
+		}).Error("Couldn't write a response")
+		return
+	}
+
+	if h.Diagnostics.Enabled(base) {
+		t := http.Header{}
+		cache.AddTimings(t, doc.timings...)
+		for k, vs := range t {
+			header[http.TrailerPrefix+k] = vs
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id": transaction.ID(base),
+	}).Debug("Finished a request")
+}
+
+// streamKey identifies an embedded document by the rel and the position in the array. It's -1 if it's not in an array.
+type streamKey struct {
+	rel string
+	pos int
+}
+
+// streamer writes a HAL+JSON document while its embedded documents are being fetched.
+type streamer struct {
+	w       io.Writer
+	doc     *document
+	fields  specifier
+	ch      <-chan *document
+	pending map[string]struct{}     // rels of the embedded documents being fetched.
+	fetched map[streamKey]*document // embedded documents fetched but not written yet.
+}
+
+// writeDocument writes the members of the document in order. Embedded documents of the pending rels are written
+// in place of the placeholders in `_embedded`.
+func (s *streamer) writeDocument(es map[string]interface{}) error {
+	o := s.doc.data.(*object)
+	if err := s.write("{"); err != nil {
+		return err
+	}
+	for i, k := range o.orderedKeys() {
+		if i > 0 {
+			if err := s.write(","); err != nil {
+				return err
+			}
+		}
+		if err := s.writeValue(k); err != nil {
+			return err
+		}
+		if err := s.write(":"); err != nil {
+			return err
+		}
+		if k != embedded {
+			if err := s.writeValue(o.members[k]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.writeEmbedded(o.members[k].(*object), es); err != nil {
+			return err
+		}
+	}
+	return s.write("}")
+}
+
+func (s *streamer) writeEmbedded(o *object, es map[string]interface{}) error {
+	if err := s.write("{"); err != nil {
+		return err
+	}
+	for i, rel := range o.orderedKeys() {
+		if i > 0 {
+			if err := s.write(","); err != nil {
+				return err
+			}
+		}
+		if err := s.writeValue(rel); err != nil {
+			return err
+		}
+		if err := s.write(":"); err != nil {
+			return err
+		}
+
+		if _, ok := s.pending[rel]; !ok {
+			if err := s.writeValue(es[rel]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		a, ok := es[rel].([]interface{})
+		if !ok {
+			if err := s.writeValue(s.wait(streamKey{rel: rel, pos: -1})); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.write("["); err != nil {
+			return err
+		}
+		for j := range a {
+			if j > 0 {
+				if err := s.write(","); err != nil {
+					return err
+				}
+			}
+			if err := s.writeValue(s.wait(streamKey{rel: rel, pos: j})); err != nil {
+				return err
+			}
+		}
+		if err := s.write("]"); err != nil {
+			return err
+		}
+	}
+	return s.write("}")
+}
+
+// wait returns the embedded document once it's fetched.
+func (s *streamer) wait(k streamKey) interface{} {
+	for {
+		if sub, ok := s.fetched[k]; ok {
+			delete(s.fetched, k)
+			if next := s.fields[k.rel]; len(next) > 0 {
+				project(sub.data, next)
+			}
+			return sub.data
+		}
+
+		// send what's written so far while waiting.
+		s.flush()
+
+		sub := <-s.ch
+		s.doc.merge(sub)
+		pos := -1
+		if sub.pos != nil {
+			pos = *sub.pos
+		}
+		s.fetched[streamKey{rel: sub.edge, pos: pos}] = sub
+	}
+}
+
+func (s *streamer) writeValue(v interface{}) error {
+	var b bytes.Buffer
+	if err := encodeJSON(&b, v); err != nil {
+		return err
+	}
+	_, err := s.w.Write(b.Bytes())
+	return err
+}
+
+func (s *streamer) write(str string) error {
+	_, err := io.WriteString(s.w, str)
+	return err
+}
+
+// flush sends the written part of the document to the client if possible.
+func (s *streamer) flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
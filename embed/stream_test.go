@@ -0,0 +1,115 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ichiban/jesi/cache"
+)
+
+func TestHandler_ServeHTTP_stream(t *testing.T) {
+	th := &slowHandler{
+		testHandler: testHandler{
+			T: t,
+			Resources: map[string]*testResource{
+				"/a": {
+					header: http.Header{"Content-Type": []string{"application/hal+json"}, "Cache-Control": []string{"max-age=60"}, "Content-Length": []string{"113"}},
+					body:   `{"_links":{"self":{"href":"/a"},"foo":{"href":"/slow"},"bar":[{"href":"/b"},{"href":"/c"}]},"title":"Pulp Fiction"}`,
+				},
+				"/b": {
+					header: http.Header{"Content-Type": []string{"application/hal+json"}},
+					body:   `{"name":"Vincent Vega"}`,
+				},
+				"/c": {
+					header: http.Header{"Content-Type": []string{"application/hal+json"}},
+					body:   `{"name":"Mia Wallace"}`,
+				},
+				"/slow": {
+					header: http.Header{"Content-Type": []string{"application/hal+json"}},
+					body:   `{"name":"Quentin Tarantino"}`,
+				},
+			},
+		},
+		release: make(chan struct{}),
+	}
+	e := Handler{
+		Next:   th,
+		Stream: true,
+	}
+
+	w := flushRecorder{release: th.release}
+	e.ServeHTTP(&w, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path:     "/a",
+			RawQuery: "with=foo&with=bar",
+		},
+	})
+
+	// the embedded documents are in order regardless of when they're fetched.
+	body := `{"_links":{"self":{"href":"/a"},"foo":{"href":"/slow"},"bar":[{"href":"/b"},{"href":"/c"}]},"title":"Pulp Fiction","_embedded":{"bar":[{"name":"Vincent Vega"},{"name":"Mia Wallace"}],"foo":{"name":"Quentin Tarantino"}}}`
+	if body != string(w.Body) {
+		t.Errorf("expected: %s, got: %s", body, string(w.Body))
+	}
+
+	// the document is written before the slow one is fetched.
+	if len(w.flushed) == 0 {
+		t.Fatal("expected to be flushed")
+	}
+	prefix := `{"_links":{"self":{"href":"/a"},"foo":{"href":"/slow"},"bar":[{"href":"/b"},{"href":"/c"}]},"title":"Pulp Fiction","_embedded":{`
+	if !strings.HasPrefix(w.flushed[0], prefix) || strings.Contains(w.flushed[0], "Tarantino") {
+		t.Errorf("expected to be flushed before fetching /slow, got: %s", w.flushed[0])
+	}
+
+	if cc := w.HeaderMap.Get(cacheControlField); cc != "no-cache,max-age=60" {
+		t.Errorf("expected: no-cache,max-age=60, got: %s", cc)
+	}
+	for _, k := range []string{etagField, contentLengthField} {
+		if v, ok := w.HeaderMap[k]; ok {
+			t.Errorf("expected no %s, got: %s", k, v)
+		}
+	}
+
+	// the document isn't streamed if there's nothing to embed.
+	w = flushRecorder{release: th.release}
+	e.ServeHTTP(&w, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path:     "/a",
+			RawQuery: "with=nope",
+		},
+	})
+
+	body = `{"_links":{"self":{"href":"/a"},"foo":{"href":"/slow"},"bar":[{"href":"/b"},{"href":"/c"}]},"title":"Pulp Fiction","_embedded":{}}`
+	if body != string(w.Body) {
+		t.Errorf("expected: %s, got: %s", body, string(w.Body))
+	}
+	if cc := w.HeaderMap.Get(cacheControlField); cc != "max-age=60" {
+		t.Errorf("expected: max-age=60, got: %s", cc)
+	}
+	for _, k := range []string{etagField, contentLengthField} {
+		if _, ok := w.HeaderMap[k]; !ok {
+			t.Errorf("expected %s", k)
+		}
+	}
+}
+
+// flushRecorder records the body written so far on every flush and releases the slow handler on the first one.
+type flushRecorder struct {
+	cache.Representation
+	release chan struct{}
+	once    sync.Once
+	flushed []string
+}
+
+var _ http.Flusher = (*flushRecorder)(nil)
+
+func (w *flushRecorder) Flush() {
+	w.flushed = append(w.flushed, string(w.Body))
+	w.once.Do(func() {
+		close(w.release)
+	})
+}
@@ -0,0 +1,268 @@
+package embed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	templated = "templated"
+
+	reserved = ":/?#[]@!$&'()*+,;="
+)
+
+// operator describes how an expression is expanded based on https://tools.ietf.org/html/rfc6570#appendix-A
+type operator struct {
+	first         string
+	sep           string
+	named         bool
+	ifEmpty       string
+	allowReserved bool
+}
+
+var operators = map[byte]operator{
+	'+': {first: "", sep: ",", allowReserved: true},
+	'#': {first: "#", sep: ",", allowReserved: true},
+	'.': {first: ".", sep: "."},
+	'/': {first: "/", sep: "/"},
+	';': {first: ";", sep: ";", named: true},
+	'?': {first: "?", sep: "&", named: true, ifEmpty: "="},
+	'&': {first: "&", sep: "&", named: true, ifEmpty: "="},
+}
+
+// stripVars removes variables for templated links such as `search.q` for `search` edge from the request and returns them by edge.
+// Edges are keyed by their rels so that `search`, `search*` and `search[name=lead]` share `search.q`.
+func stripVars(req *http.Request, spec specifier) map[string]url.Values {
+	edges := map[string]struct{}{}
+	spec.each(func(edge string) {
+		edges[varsKey(edge)] = struct{}{}
+	})
+
+	vars := map[string]url.Values{}
+	q := req.URL.Query()
+	for k, vs := range q {
+		i := varsSep(k)
+		if i < 0 {
+			continue
+		}
+
+		edge, name := varsKey(k[:i]), k[i+1:]
+		if _, ok := edges[edge]; !ok {
+			continue
+		}
+
+		if vars[edge] == nil {
+			vars[edge] = url.Values{}
+		}
+		vars[edge][name] = vs
+		q.Del(k)
+	}
+
+	if len(vars) > 0 {
+		req.URL.RawQuery = q.Encode()
+	}
+
+	return vars
+}
+
+// varsKey returns the rel of the edge by which variables for templated links are keyed.
+func varsKey(edge string) string {
+	edge, _ = parsePaginated(edge)
+	rel, _ := parseEdge(edge)
+	return rel
+}
+
+// varsSep returns the index of the dot separating the edge and the variable name in the query parameter
+// or -1 if there's none. Dots in filters such as `search[profile=http://example.com/search].q` don't separate them.
+func varsSep(k string) int {
+	filter := false
+	for i := 0; i < len(k); i++ {
+		switch k[i] {
+		case '[':
+			filter = true
+		case ']':
+			filter = false
+		case '.':
+			if !filter {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// skip returns true if the link is templated but there's no variables for it.
+func skip(vars url.Values, link map[string]interface{}) bool {
+	t, _ := link[templated].(bool)
+	return t && len(vars) == 0
+}
+
+// linkHref returns the URL of the link. If the link is templated, it's expanded with the variables.
+func linkHref(vars url.Values, link map[string]interface{}) (string, error) {
+	h, ok := link[href].(string)
+	if !ok {
+		return "", errors.New("link without href")
+	}
+
+	if t, _ := link[templated].(bool); !t {
+		return h, nil
+	}
+
+	return expand(h, vars)
+}
+
+// expand expands a URI template described in RFC 6570 with the variables.
+// A variable with a single value is a string and one with multiple values is a list.
+func expand(template string, vars map[string][]string) (string, error) {
+	var b bytes.Buffer
+	for {
+		i := strings.IndexByte(template, '{')
+		if i < 0 {
+			if strings.IndexByte(template, '}') >= 0 {
+				return "", errors.New("unexpected '}' in URI template")
+			}
+			b.WriteString(template)
+			return b.String(), nil
+		}
+		b.WriteString(template[:i])
+		template = template[i+1:]
+
+		j := strings.IndexByte(template, '}')
+		if j < 0 {
+			return "", errors.New("unclosed '{' in URI template")
+		}
+		if err := expandExpression(&b, template[:j], vars); err != nil {
+			return "", err
+		}
+		template = template[j+1:]
+	}
+}
+
+func expandExpression(b *bytes.Buffer, expr string, vars map[string][]string) error {
+	if expr == "" {
+		return errors.New("empty expression in URI template")
+	}
+
+	op, ok := operators[expr[0]]
+	if ok {
+		expr = expr[1:]
+	} else {
+		op = operator{first: "", sep: ","}
+	}
+
+	first := true
+	for _, spec := range strings.Split(expr, ",") {
+		name, explode, prefix, err := parseVarspec(spec)
+		if err != nil {
+			return err
+		}
+
+		vs := vars[name]
+		if len(vs) == 0 {
+			continue
+		}
+
+		if first {
+			b.WriteString(op.first)
+			first = false
+		} else {
+			b.WriteString(op.sep)
+		}
+
+		if len(vs) == 1 {
+			v := vs[0]
+			if prefix > 0 {
+				if r := []rune(v); len(r) > prefix {
+					v = string(r[:prefix])
+				}
+			}
+			writeNamed(b, op, name, v)
+			continue
+		}
+
+		if !explode {
+			if op.named {
+				b.WriteString(name)
+				b.WriteString("=")
+			}
+			for k, v := range vs {
+				if k > 0 {
+					b.WriteString(",")
+				}
+				b.WriteString(encode(v, op.allowReserved))
+			}
+			continue
+		}
+
+		for k, v := range vs {
+			if k > 0 {
+				b.WriteString(op.sep)
+			}
+			writeNamed(b, op, name, v)
+		}
+	}
+
+	return nil
+}
+
+func writeNamed(b *bytes.Buffer, op operator, name, value string) {
+	if op.named {
+		b.WriteString(name)
+		if value == "" {
+			b.WriteString(op.ifEmpty)
+			return
+		}
+		b.WriteString("=")
+	}
+	b.WriteString(encode(value, op.allowReserved))
+}
+
+func parseVarspec(spec string) (string, bool, int, error) {
+	if strings.HasSuffix(spec, "*") {
+		return spec[:len(spec)-1], true, 0, nil
+	}
+
+	i := strings.IndexByte(spec, ':')
+	if i < 0 {
+		return spec, false, 0, nil
+	}
+
+	n, err := strconv.Atoi(spec[i+1:])
+	if err != nil || n <= 0 || n >= 10000 {
+		return "", false, 0, fmt.Errorf("invalid prefix modifier in URI template: %s", spec)
+	}
+
+	return spec[:i], false, n, nil
+}
+
+// encode percent-encodes characters other than unreserved ones and, if allowed, reserved ones and pct-encoded triplets.
+func encode(s string, allowReserved bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case unreserved(c):
+			b.WriteByte(c)
+		case allowReserved && strings.IndexByte(reserved, c) >= 0:
+			b.WriteByte(c)
+		case allowReserved && c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func unreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
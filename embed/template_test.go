@@ -0,0 +1,105 @@
+package embed
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string][]string{
+		"var":   {"value"},
+		"hello": {"Hello World!"},
+		"path":  {"/foo/bar"},
+		"empty": {""},
+		"list":  {"red", "green", "blue"},
+		"x":     {"1024"},
+		"y":     {"768"},
+	}
+
+	testCases := []struct {
+		template string
+		uri      string
+	}{
+		// examples from https://tools.ietf.org/html/rfc6570
+		{template: "{var}", uri: "value"},
+		{template: "{hello}", uri: "Hello%20World%21"},
+		{template: "{+hello}", uri: "Hello%20World!"},
+		{template: "{+path}/here", uri: "/foo/bar/here"},
+		{template: "here?ref={+path}", uri: "here?ref=/foo/bar"},
+		{template: "{#path}", uri: "#/foo/bar"},
+		{template: "map?{x,y}", uri: "map?1024,768"},
+		{template: "{var:3}", uri: "val"},
+		{template: "{list}", uri: "red,green,blue"},
+		{template: "{list*}", uri: "red,green,blue"},
+		{template: "X{.var}", uri: "X.value"},
+		{template: "X{.list*}", uri: "X.red.green.blue"},
+		{template: "{/var,x}/here", uri: "/value/1024/here"},
+		{template: "{/list*}", uri: "/red/green/blue"},
+		{template: "{;x,y,empty}", uri: ";x=1024;y=768;empty"},
+		{template: "{?x,y,empty}", uri: "?x=1024&y=768&empty="},
+		{template: "{?list}", uri: "?list=red,green,blue"},
+		{template: "{?list*}", uri: "?list=red&list=green&list=blue"},
+		{template: "?fixed=yes{&x}", uri: "?fixed=yes&x=1024"},
+		{template: "/search{?q,page}", uri: "/search"},
+	}
+
+	for _, tc := range testCases {
+		uri, err := expand(tc.template, vars)
+		if err != nil {
+			t.Errorf("(%s) %v", tc.template, err)
+		}
+		if tc.uri != uri {
+			t.Errorf("(%s) expected: %s, got: %s", tc.template, tc.uri, uri)
+		}
+	}
+
+	for _, template := range []string{"/search{?q", "/search}", "/search{}", "{var:x}"} {
+		if _, err := expand(template, vars); err == nil {
+			t.Errorf("(%s) expected an error", template)
+		}
+	}
+}
+
+func TestStripVars(t *testing.T) {
+	req := &http.Request{
+		URL: &url.URL{
+			Path:     "/a",
+			RawQuery: "search.q=foo&other.q=bar&page=1",
+		},
+	}
+
+	vars := stripVars(req, specifier{"search": specifier{}})
+
+	if q := vars["search"].Get("q"); q != "foo" {
+		t.Errorf("expected foo, got %s", q)
+	}
+	if _, ok := vars["other"]; ok {
+		t.Errorf("expected no variables for other, got %v", vars["other"])
+	}
+	if q := req.URL.RawQuery; q != "other.q=bar&page=1" {
+		t.Errorf("expected other.q=bar&page=1, got %s", q)
+	}
+
+	// variables are keyed by the rels of the edges.
+	req = &http.Request{
+		URL: &url.URL{
+			Path:     "/a",
+			RawQuery: "search%5Bprofile%3Dhttp%3A%2F%2Fexample.com%2Ftext%5D.q=foo&ex%3Afind.q=bar&roles.name=baz",
+		},
+	}
+
+	vars = stripVars(req, specifier{"search[profile=http://example.com/text]": specifier{}, "ex:find": specifier{}, "roles*": specifier{}})
+
+	for edge, q := range map[string]string{"search": "foo", "ex:find": "bar"} {
+		if v := vars[edge].Get("q"); v != q {
+			t.Errorf("(%s) expected %s, got %s", edge, q, v)
+		}
+	}
+	if n := vars["roles"].Get("name"); n != "baz" {
+		t.Errorf("expected baz, got %s", n)
+	}
+	if q := req.URL.RawQuery; q != "" {
+		t.Errorf("expected no query, got %s", q)
+	}
+}
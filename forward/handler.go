@@ -2,6 +2,7 @@ package forward
 
 import (
 	"io"
+	"net"
 	"net/http"
 
 	log "github.com/sirupsen/logrus"
@@ -25,6 +26,11 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"error": err,
 		}).Error("failed to forward a request")
 
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+
 		w.WriteHeader(http.StatusBadGateway)
 		return
 	}
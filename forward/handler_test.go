@@ -32,6 +32,12 @@ func TestHandler_ServeHTTP(t *testing.T) {
 			expectedReq:  &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/foo"}},
 			expectedResp: &http.Response{StatusCode: http.StatusBadGateway, Body: ioutil.NopCloser(strings.NewReader(""))},
 		},
+		{ // timeout
+			givenReq:     &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/foo"}},
+			givenErr:     timeoutError{},
+			expectedReq:  &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/foo"}},
+			expectedResp: &http.Response{StatusCode: http.StatusGatewayTimeout, Body: ioutil.NopCloser(strings.NewReader(""))},
+		},
 	}
 
 	for i, tc := range testCases {
@@ -105,3 +111,9 @@ func (t *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	t.req = req
 	return t.resp, t.err
 }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
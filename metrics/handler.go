@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Handler exposes registered metrics in Prometheus text format.
+type Handler struct{}
+
+var _ http.Handler = (*Handler)(nil)
+
+// ServeHTTP writes all the registered metrics.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range registered() {
+		if _, err := m.WriteTo(w); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Couldn't write metrics")
+			return
+		}
+	}
+}
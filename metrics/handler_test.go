@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	c := NewCounter("jesi_test_total", "Test.", "state")
+	c.Inc("fresh")
+
+	var h Handler
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("expected text/plain; version=0.0.4, got %s", ct)
+	}
+
+	if !strings.Contains(w.Body.String(), `jesi_test_total{state="fresh"} 1`) {
+		t.Errorf("expected to contain the counter, got %s", w.Body.String())
+	}
+}
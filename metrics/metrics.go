@@ -0,0 +1,352 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are histogram buckets in seconds suitable for HTTP latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric is a family of samples which can be written in Prometheus text format.
+type Metric interface {
+	WriteTo(w io.Writer) (int64, error)
+}
+
+var registry struct {
+	sync.Mutex
+	metrics []Metric
+}
+
+// Register adds metrics to be exposed by Handler.
+func Register(ms ...Metric) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.metrics = append(registry.metrics, ms...)
+}
+
+func registered() []Metric {
+	registry.Lock()
+	defer registry.Unlock()
+
+	ms := make([]Metric, len(registry.metrics))
+	copy(ms, registry.metrics)
+	return ms
+}
+
+// Sample is a value with label values.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Counter is a monotonically increasing value partitioned by labels.
+type Counter struct {
+	sync.Mutex
+	Name   string
+	Help   string
+	Labels []string
+
+	samples map[string]*Sample
+}
+
+var _ Metric = (*Counter)(nil)
+
+// NewCounter creates and registers a new counter.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := Counter{
+		Name:   name,
+		Help:   help,
+		Labels: labels,
+	}
+	Register(&c)
+	return &c
+}
+
+// Inc increments the counter for the label values by 1.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v to the counter for the label values.
+func (c *Counter) Add(v float64, labels ...string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.samples == nil {
+		c.samples = make(map[string]*Sample)
+	}
+
+	k := key(labels)
+	s, ok := c.samples[k]
+	if !ok {
+		s = &Sample{Labels: labels}
+		c.samples[k] = s
+	}
+	s.Value += v
+}
+
+// Value returns the current value for the label values.
+func (c *Counter) Value(labels ...string) float64 {
+	c.Lock()
+	defer c.Unlock()
+
+	s, ok := c.samples[key(labels)]
+	if !ok {
+		return 0
+	}
+	return s.Value
+}
+
+// WriteTo writes the counter in Prometheus text format.
+func (c *Counter) WriteTo(w io.Writer) (int64, error) {
+	c.Lock()
+	ss := make([]Sample, 0, len(c.samples))
+	for _, s := range c.samples {
+		ss = append(ss, *s)
+	}
+	c.Unlock()
+
+	return writeSamples(w, c.Name, c.Help, "counter", c.Labels, ss)
+}
+
+// Func is a metric whose samples are collected when it's written.
+type Func struct {
+	Name    string
+	Help    string
+	Type    string
+	Labels  []string
+	Collect func() []Sample
+}
+
+var _ Metric = (*Func)(nil)
+
+// NewGaugeFunc creates and registers a new gauge collected by f.
+func NewGaugeFunc(name, help string, f func() []Sample, labels ...string) *Func {
+	g := Func{
+		Name:    name,
+		Help:    help,
+		Type:    "gauge",
+		Labels:  labels,
+		Collect: f,
+	}
+	Register(&g)
+	return &g
+}
+
+// NewCounterFunc creates and registers a new counter collected by f.
+func NewCounterFunc(name, help string, f func() []Sample, labels ...string) *Func {
+	c := Func{
+		Name:    name,
+		Help:    help,
+		Type:    "counter",
+		Labels:  labels,
+		Collect: f,
+	}
+	Register(&c)
+	return &c
+}
+
+// WriteTo writes the collected samples in Prometheus text format.
+func (f *Func) WriteTo(w io.Writer) (int64, error) {
+	return writeSamples(w, f.Name, f.Help, f.Type, f.Labels, f.Collect())
+}
+
+// Histogram counts observations in buckets partitioned by labels.
+type Histogram struct {
+	sync.Mutex
+	Name    string
+	Help    string
+	Labels  []string
+	Buckets []float64
+
+	observations map[string]*observation
+}
+
+var _ Metric = (*Histogram)(nil)
+
+type observation struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates and registers a new histogram with DefaultBuckets.
+func NewHistogram(name, help string, labels ...string) *Histogram {
+	h := Histogram{
+		Name:    name,
+		Help:    help,
+		Labels:  labels,
+		Buckets: DefaultBuckets,
+	}
+	Register(&h)
+	return &h
+}
+
+// Observe adds an observation for the label values.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.observations == nil {
+		h.observations = make(map[string]*observation)
+	}
+
+	k := key(labels)
+	o, ok := h.observations[k]
+	if !ok {
+		o = &observation{
+			labels: labels,
+			counts: make([]uint64, len(h.Buckets)),
+		}
+		h.observations[k] = o
+	}
+
+	for i, b := range h.Buckets {
+		if v <= b {
+			o.counts[i]++
+		}
+	}
+	o.count++
+	o.sum += v
+}
+
+// Since observes the duration since t in seconds for the label values.
+func (h *Histogram) Since(t time.Time, labels ...string) {
+	h.Observe(time.Since(t).Seconds(), labels...)
+}
+
+// WriteTo writes the histogram in Prometheus text format.
+func (h *Histogram) WriteTo(w io.Writer) (int64, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	keys := make([]string, 0, len(h.observations))
+	for k := range h.observations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buckets, sums, counts []Sample
+	for _, k := range keys {
+		o := h.observations[k]
+		for i, b := range h.Buckets {
+			buckets = append(buckets, Sample{
+				Labels: append(append([]string{}, o.labels...), formatFloat(b)),
+				Value:  float64(o.counts[i]),
+			})
+		}
+		buckets = append(buckets, Sample{
+			Labels: append(append([]string{}, o.labels...), formatFloat(math.Inf(1))),
+			Value:  float64(o.count),
+		})
+		sums = append(sums, Sample{Labels: o.labels, Value: o.sum})
+		counts = append(counts, Sample{Labels: o.labels, Value: float64(o.count)})
+	}
+
+	n, err := writeHeader(w, h.Name, h.Help, "histogram")
+	if err != nil {
+		return n, err
+	}
+
+	for _, part := range []struct {
+		suffix  string
+		labels  []string
+		samples []Sample
+	}{
+		{suffix: "_bucket", labels: append(append([]string{}, h.Labels...), "le"), samples: buckets},
+		{suffix: "_sum", labels: h.Labels, samples: sums},
+		{suffix: "_count", labels: h.Labels, samples: counts},
+	} {
+		m, err := writeValues(w, h.Name+part.suffix, part.labels, part.samples)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func writeSamples(w io.Writer, name, help, typ string, labels []string, ss []Sample) (int64, error) {
+	n, err := writeHeader(w, name, help, typ)
+	if err != nil {
+		return n, err
+	}
+
+	sort.Slice(ss, func(i, j int) bool {
+		return key(ss[i].Labels) < key(ss[j].Labels)
+	})
+
+	m, err := writeValues(w, name, labels, ss)
+	return n + m, err
+}
+
+func writeHeader(w io.Writer, name, help, typ string) (int64, error) {
+	n, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+	return int64(n), err
+}
+
+func writeValues(w io.Writer, name string, labels []string, ss []Sample) (int64, error) {
+	var total int64
+	for _, s := range ss {
+		var pairs []string
+		for i, l := range labels {
+			var v string
+			if i < len(s.Labels) {
+				v = s.Labels[i]
+			}
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, escapeLabel(v)))
+		}
+
+		var n int
+		var err error
+		if len(pairs) == 0 {
+			n, err = fmt.Fprintf(w, "%s %s\n", name, formatFloat(s.Value))
+		} else {
+			n, err = fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(s.Value))
+		}
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+func key(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCounter_WriteTo(t *testing.T) {
+	testCases := []struct {
+		counter *Counter
+		incs    [][]string
+
+		out string
+	}{
+		{ // without labels
+			counter: &Counter{Name: "test_total", Help: "Test."},
+			incs:    [][]string{{}, {}},
+			out: `# HELP test_total Test.
+# TYPE test_total counter
+test_total 2
+`,
+		},
+		{ // with labels
+			counter: &Counter{Name: "test_total", Help: "Test.", Labels: []string{"state"}},
+			incs:    [][]string{{"stale"}, {"fresh"}, {"fresh"}},
+			out: `# HELP test_total Test.
+# TYPE test_total counter
+test_total{state="fresh"} 2
+test_total{state="stale"} 1
+`,
+		},
+		{ // with escaped label values
+			counter: &Counter{Name: "test_total", Help: "Test.\nMultiline.", Labels: []string{"path"}},
+			incs:    [][]string{{`"a\b"`}},
+			out: `# HELP test_total Test.\nMultiline.
+# TYPE test_total counter
+test_total{path="\"a\\b\""} 1
+`,
+		},
+	}
+
+	for i, tc := range testCases {
+		for _, inc := range tc.incs {
+			tc.counter.Inc(inc...)
+		}
+
+		var b bytes.Buffer
+		if _, err := tc.counter.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		if tc.out != b.String() {
+			t.Errorf("(%d) expected %s, got %s", i, tc.out, b.String())
+		}
+	}
+}
+
+func TestFunc_WriteTo(t *testing.T) {
+	f := Func{
+		Name:   "test_bytes",
+		Help:   "Test.",
+		Type:   "gauge",
+		Labels: []string{"tier"},
+		Collect: func() []Sample {
+			return []Sample{
+				{Labels: []string{"memory"}, Value: 1024},
+				{Labels: []string{"disk"}, Value: 0.5},
+			}
+		},
+	}
+
+	var b bytes.Buffer
+	if _, err := f.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	out := `# HELP test_bytes Test.
+# TYPE test_bytes gauge
+test_bytes{tier="disk"} 0.5
+test_bytes{tier="memory"} 1024
+`
+	if out != b.String() {
+		t.Errorf("expected %s, got %s", out, b.String())
+	}
+}
+
+func TestHistogram_WriteTo(t *testing.T) {
+	h := Histogram{
+		Name:    "test_seconds",
+		Help:    "Test.",
+		Labels:  []string{"backend"},
+		Buckets: []float64{.1, 1},
+	}
+
+	h.Observe(.05, "a")
+	h.Observe(.5, "a")
+	h.Observe(5, "a")
+
+	var b bytes.Buffer
+	if _, err := h.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	out := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{backend="a",le="0.1"} 1
+test_seconds_bucket{backend="a",le="1"} 2
+test_seconds_bucket{backend="a",le="+Inf"} 3
+test_seconds_sum{backend="a"} 5.55
+test_seconds_count{backend="a"} 3
+`
+	if out != b.String() {
+		t.Errorf("expected %s, got %s", out, b.String())
+	}
+}
@@ -33,3 +33,12 @@ func ID(r *http.Request) *uuid.UUID {
 	id := v.(uuid.UUID)
 	return &id
 }
+
+// Detach returns a request which isn't canceled along with a given request but keeps its ID.
+func Detach(r *http.Request) *http.Request {
+	ctx := context.Background()
+	if v := r.Context().Value(IDKey); v != nil {
+		ctx = context.WithValue(ctx, IDKey, v)
+	}
+	return r.WithContext(ctx)
+}